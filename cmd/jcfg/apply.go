@@ -2,17 +2,29 @@ package main
 
 import (
 	"context"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"example.com/jcfg/pkg/catalog"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
+var Noop bool
+var ReportFile string
+var Parallelism int
+var Selectors []string
+var SelectKeys []string
+var WithDeps bool
+
 func init() {
 	applyCommand := &cobra.Command{
 		Use:   "apply GraphFile",
 		Short: "Apply a json file",
-		Long:  `Read in a compiled catalog/graph and enforce that state on the system.`,
+		Long: `Read in a compiled catalog/graph and enforce that state on the system.
+Exits non-zero if any resource failed.`,
+		SilenceUsage: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return applyCmd(cmd, args)
 		},
@@ -23,6 +35,28 @@ func init() {
 			return nil
 		},
 	}
+	applyCommand.Flags().BoolVar(
+		&Noop, "noop", false, "report what would change without applying",
+	)
+	applyCommand.Flags().StringVar(
+		&ReportFile, "report", "", "write a json report of the apply to this file",
+	)
+	applyCommand.Flags().IntVar(
+		&Parallelism, "parallelism", 0,
+		"max resources to apply at once, 0 for no limit",
+	)
+	applyCommand.Flags().StringArrayVar(
+		&Selectors, "select", []string{},
+		"only apply resources matching selector, e.g. 'kind=File,label:role=yum'",
+	)
+	applyCommand.Flags().StringArrayVar(
+		&SelectKeys, "resource", []string{},
+		"only apply this resource, e.g. 'Exec::Install package openssh-server'",
+	)
+	applyCommand.Flags().BoolVar(
+		&WithDeps, "with-deps", false,
+		"also apply everything selected resources depend on",
+	)
 	rootCmd.AddCommand(applyCommand)
 }
 
@@ -35,14 +69,78 @@ func applyCmd(c *cobra.Command, args []string) error {
 	if err != nil {
 		return errors.Errorf("Error building catalog to apply: %s", err)
 	}
-	g := catalog.Graph{}
+	g := catalog.Graph{Noop: Noop, Parallelism: Parallelism}
 	if err := g.LoadCatalog(loadedCatalog, log); err != nil {
 		return errors.Errorf("Error loading catalog into graph to apply: %s", err)
 	}
+	// Narrow down to a partial apply if asked
+	if len(Selectors) != 0 || len(SelectKeys) != 0 {
+		var sels []*catalog.Selector
+		for _, expr := range Selectors {
+			sel, err := catalog.ParseSelector(expr)
+			if err != nil {
+				return errors.Errorf("Error parsing selector: %s", err)
+			}
+			sels = append(sels, sel)
+		}
+		if err := g.Select(sels, SelectKeys, WithDeps, log); err != nil {
+			return errors.Errorf("Error selecting resources to apply: %s", err)
+		}
+	}
 	// Walk graph, applying resources
-	ctx := context.Background()
-	if err := g.Apply(ctx, log); err != nil {
-		return errors.Errorf("Error applying catalog: %s", err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopSignals := handleSignals(&g, cancel)
+	defer stopSignals()
+	applyErr := g.Apply(ctx, log)
+	if ReportFile != "" {
+		if err := g.WriteReport(ReportFile); err != nil {
+			return errors.Errorf("Error writing report: %s", err)
+		}
+	}
+	if Noop {
+		if err := g.WriteNoop(os.Stdout); err != nil {
+			return err
+		}
+	}
+	if applyErr != nil {
+		return errors.Errorf("Error applying catalog: %s", applyErr)
 	}
 	return nil
 }
+
+// Trap signals while applying. SIGUSR1 dumps the status of the graph. The
+// first SIGINT or SIGTERM dumps status and cancels the apply, so running
+// execs are killed and pending resources are cancelled; a second forces exit.
+// Returns a func to stop handling signals.
+func handleSignals(g *catalog.Graph, cancel context.CancelFunc) func() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR1)
+	stop := make(chan struct{})
+	go func() {
+		cancelled := false
+		for {
+			select {
+			case <-stop:
+				return
+			case sig := <-sigs:
+				if sig == syscall.SIGUSR1 {
+					g.WriteStatus(os.Stderr)
+					continue
+				}
+				if cancelled {
+					log.Errorf("Caught second %v, exiting now\n", sig)
+					os.Exit(130)
+				}
+				cancelled = true
+				log.Warnf("Caught %v, cancelling apply. Repeat to force exit\n", sig)
+				g.WriteStatus(os.Stderr)
+				cancel()
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(stop)
+	}
+}
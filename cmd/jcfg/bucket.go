@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"example.com/jcfg/pkg/atomicfile"
+	"example.com/jcfg/pkg/resources"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var BucketSum string
+
+func init() {
+	bucketCommand := &cobra.Command{
+		Use:   "bucket",
+		Short: "Inspect and restore file backups",
+		Long: `Files are backed up to the bucket before apply replaces their content or
+removes them. List the backups kept, show one, or restore one over its file.`,
+	}
+	listCommand := &cobra.Command{
+		Use:          "list [Path]",
+		Short:        "List backups, of every file or just Path, oldest first",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return bucketListCmd(cmd, args)
+		},
+	}
+	showCommand := &cobra.Command{
+		Use:          "show Path",
+		Short:        "Print the latest backup of Path, or the one set w/ --sum",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return bucketShowCmd(cmd, args)
+		},
+		Args: bucketPathArg,
+	}
+	restoreCommand := &cobra.Command{
+		Use:   "restore Path",
+		Short: "Restore the latest backup of Path, or the one set w/ --sum",
+		Long: `Write a backup over the file it was taken from, w/ the mode and owner
+the file had then. The current content is backed up first.`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return bucketRestoreCmd(cmd, args)
+		},
+		Args: bucketPathArg,
+	}
+	for _, c := range []*cobra.Command{showCommand, restoreCommand} {
+		c.Flags().StringVar(
+			&BucketSum, "sum", "", "SHA-256 of the backup, defaults to the latest",
+		)
+	}
+	bucketCommand.AddCommand(listCommand, showCommand, restoreCommand)
+	rootCmd.AddCommand(bucketCommand)
+}
+
+func bucketPathArg(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		log.Fatalf(errors.New("no path specified on command line").Error())
+	}
+	return nil
+}
+
+// Get the path backups of a file given on the command line are kept under.
+// Backups are kept by absolute path, and under the target of a link.
+func bucketPath(arg string) (string, error) {
+	fp, err := filepath.Abs(arg)
+	if err != nil {
+		return "", errors.Errorf("Unable to make %s absolute: %v", arg, err)
+	}
+	return resources.ResolveLink(fp)
+}
+
+func bucketListCmd(c *cobra.Command, args []string) error {
+	setupLogger()
+	if resources.BackupBucket == nil {
+		return errors.Errorf("No bucket dir set")
+	}
+	fp := ""
+	if len(args) != 0 {
+		var err error
+		if fp, err = bucketPath(args[0]); err != nil {
+			return err
+		}
+	}
+	entries, err := resources.BackupBucket.List(fp)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "TIME\tSUM\tSIZE\tPATH\n")
+	for _, entry := range entries {
+		fmt.Fprintf(
+			w, "%s\t%s\t%d\t%s\n", entry.Time.Format("2006-01-02T15:04:05Z"),
+			entry.Sum, entry.Size, entry.Path,
+		)
+	}
+	return w.Flush()
+}
+
+func bucketShowCmd(c *cobra.Command, args []string) error {
+	setupLogger()
+	if resources.BackupBucket == nil {
+		return errors.Errorf("No bucket dir set")
+	}
+	fp, err := bucketPath(args[0])
+	if err != nil {
+		return err
+	}
+	entry, err := resources.BackupBucket.Find(fp, BucketSum)
+	if err != nil {
+		return err
+	}
+	data, err := resources.BackupBucket.Content(entry)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+func bucketRestoreCmd(c *cobra.Command, args []string) error {
+	setupLogger()
+	if resources.BackupBucket == nil {
+		return errors.Errorf("No bucket dir set")
+	}
+	// A file reached through a link is restored w/o replacing the link
+	fp, err := bucketPath(args[0])
+	if err != nil {
+		return err
+	}
+	entry, err := resources.BackupBucket.Find(fp, BucketSum)
+	if err != nil {
+		return err
+	}
+	data, err := resources.BackupBucket.Content(entry)
+	if err != nil {
+		return err
+	}
+	// Keep what's there now, so the restore can be undone
+	if fi, err := os.Lstat(fp); err == nil && fi.Mode().IsRegular() {
+		current, err := resources.BackupBucket.Backup(fp)
+		if err != nil {
+			return errors.Errorf("Unable to back up %s before restoring: %v", fp, err)
+		}
+		log.Infof("Backed up %s to bucket as %s\n", fp, current.Sum)
+	}
+	owner := &atomicfile.Owner{Uid: entry.Uid, Gid: entry.Gid}
+	err = atomicfile.Write(fp, data, entry.Mode, owner)
+	if err != nil {
+		return errors.Errorf("Unable to restore %s: %v", fp, err)
+	}
+	fmt.Printf("Restored %s from %s (%s)\n", fp, entry.Sum, entry.Time.Format("2006-01-02T15:04:05Z"))
+	return nil
+}
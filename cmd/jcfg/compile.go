@@ -1,23 +1,84 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"example.com/jcfg/pkg/catalog"
+	"example.com/jcfg/pkg/compiler"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
+var JPaths []string
+var CompileOutput string
+
 func init() {
 	compileCommand := &cobra.Command{
-		Use:   "compile",
-		Short: "Compile a catalog into a graph to apply",
+		Use:   "compile ManifestFile",
+		Short: "Compile a jsonnet manifest into a catalog to apply",
+		Long: `Evaluate a jsonnet manifest, flatten the resources it returns and
+validate them as a catalog. The catalog is written to stdout or the file set
+with --output. Library paths are read from JSONNET_PATH and --jpath.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return compileCmd(cmd, args)
 		},
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 {
+				log.Fatalf(errors.New("no jsonnet file specified on command line").Error())
+			}
+			return nil
+		},
 	}
+	compileCommand.Flags().StringArrayVarP(
+		&JPaths, "jpath", "J", []string{}, "library search path, may be repeated",
+	)
+	compileCommand.Flags().StringVarP(
+		&CompileOutput, "output", "o", "", "file to write the catalog to",
+	)
 	rootCmd.AddCommand(compileCommand)
 }
 
 func compileCmd(c *cobra.Command, args []string) error {
+	setupLogger()
 	// Read file
+	manifestFile := args[0]
+	// Later paths take precedence, so flag values win over JSONNET_PATH
+	jpaths := []string{}
+	if env := os.Getenv("JSONNET_PATH"); env != "" {
+		jpaths = append(jpaths, filepath.SplitList(env)...)
+	}
+	jpaths = append(jpaths, JPaths...)
+	doc, err := compiler.Compile(manifestFile, jpaths, log)
+	if err != nil {
+		return errors.Errorf("Error compiling %s: %s", manifestFile, err)
+	}
+	data, err := json.MarshalIndent(doc.Catalog(), "", "  ")
+	if err != nil {
+		return errors.Errorf("Error marshalling catalog: %s", err)
+	}
+
 	// Build graph, check for errors
-	return errors.Errorf("Nothing to see here")
+	loadedCatalog, err := catalog.ParseCatalog(data, log)
+	if err != nil {
+		return errors.Errorf("Error building catalog from %s: %s", manifestFile, err)
+	}
+	g := catalog.Graph{}
+	if err := g.LoadCatalog(loadedCatalog, log); err != nil {
+		return errors.Errorf("Error loading catalog into graph: %s", err)
+	}
+
+	// Write out
+	if CompileOutput == "" {
+		fmt.Printf("%s\n", data)
+		return nil
+	}
+	if err := ioutil.WriteFile(CompileOutput, append(data, '\n'), 0644); err != nil {
+		return errors.Errorf("Error writing catalog to %s: %s", CompileOutput, err)
+	}
+	log.Infof("Wrote %d resources to %s\n", len(doc.Resources), CompileOutput)
+	return nil
 }
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"example.com/jcfg/pkg/facts"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	factsCommand := &cobra.Command{
+		Use:   "facts",
+		Short: "Print the facts about this node templates are rendered w/",
+		Long: `Gather facts about this node and print them as json. Templates read
+them as .Facts, w/ each field named as in Go, e.g. {{ .Facts.OS.VersionID }}.`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return factsCmd(cmd, args)
+		},
+	}
+	rootCmd.AddCommand(factsCommand)
+}
+
+func factsCmd(c *cobra.Command, args []string) error {
+	setupLogger()
+	f, err := facts.Gather()
+	if err != nil {
+		return errors.Errorf("Error gathering facts: %s", err)
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return errors.Errorf("Error marshalling facts: %s", err)
+	}
+	fmt.Printf("%s\n", data)
+	return nil
+}
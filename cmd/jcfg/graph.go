@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+
+	"example.com/jcfg/pkg/catalog"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var GraphFormat string
+var GraphReportFile string
+
+func init() {
+	graphCommand := &cobra.Command{
+		Use:   "graph GraphFile",
+		Short: "Export the ordering of a catalog",
+		Long: `Load a compiled catalog and write its resource graph to stdout, as
+Graphviz DOT or a json adjacency list. Nodes can be coloured by their outcome
+in a report written by apply --report.`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return graphCmd(cmd, args)
+		},
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 {
+				log.Fatalf(errors.New("no json file specified on command line").Error())
+			}
+			return nil
+		},
+	}
+	graphCommand.Flags().StringVar(
+		&GraphFormat, "format", "dot", "output format, dot or json",
+	)
+	graphCommand.Flags().StringVar(
+		&GraphReportFile, "report", "",
+		"apply report to take resource outcomes from",
+	)
+	rootCmd.AddCommand(graphCommand)
+}
+
+func graphCmd(c *cobra.Command, args []string) error {
+	setupLogger()
+	if GraphFormat != "dot" && GraphFormat != "json" {
+		return errors.Errorf("Unknown graph format %s, expected dot or json", GraphFormat)
+	}
+	// Read file
+	graphFile := args[0]
+	// Build graph
+	loadedCatalog, err := catalog.NewCatalog(graphFile, log)
+	if err != nil {
+		return errors.Errorf("Error building catalog to graph: %s", err)
+	}
+	g := catalog.Graph{}
+	if err := g.LoadCatalog(loadedCatalog, log); err != nil {
+		return errors.Errorf("Error loading catalog into graph: %s", err)
+	}
+	var report *catalog.Report
+	if GraphReportFile != "" {
+		if report, err = catalog.ReadReport(GraphReportFile); err != nil {
+			return err
+		}
+	}
+
+	if GraphFormat == "json" {
+		return g.WriteJSON(os.Stdout, report)
+	}
+	return g.WriteDot(os.Stdout, report)
+}
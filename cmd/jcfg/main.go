@@ -1,12 +1,21 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
+
+	"example.com/jcfg/pkg/bucket"
+	"example.com/jcfg/pkg/fetch"
+	"example.com/jcfg/pkg/resources"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
 var Verbose bool
 var Debug bool
+var PluginPath string
+var BucketDir string
+var CacheDir string
 
 var log = logrus.New()
 
@@ -16,7 +25,7 @@ var rootCmd = &cobra.Command{
 }
 
 func init() {
-	cobra.OnInitialize()
+	cobra.OnInitialize(setupPlugins, setupBucket, setupFetcher)
 	// Add base flags here
 	rootCmd.PersistentFlags().BoolVarP(
 		&Verbose, "verbose", "v", false, "verbose output",
@@ -24,6 +33,34 @@ func init() {
 	rootCmd.PersistentFlags().BoolVarP(
 		&Debug, "debug", "d", false, "debug output",
 	)
+	rootCmd.PersistentFlags().StringVar(
+		&PluginPath, "plugin-path", os.Getenv("JCFG_PLUGIN_PATH"),
+		"directories to search for resource plugins, defaults to PATH",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&BucketDir, "bucket-dir", bucket.DefaultDir,
+		"directory file backups are kept in, empty to disable backups",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&CacheDir, "cache-dir", fetch.DefaultCacheDir,
+		"directory http sources are cached in, empty to disable caching",
+	)
+}
+
+func setupPlugins() {
+	if PluginPath != "" {
+		resources.PluginPath = filepath.SplitList(PluginPath)
+	}
+}
+
+func setupBucket() {
+	if BucketDir != "" {
+		resources.BackupBucket = bucket.New(BucketDir)
+	}
+}
+
+func setupFetcher() {
+	resources.HTTPFetcher = fetch.New(CacheDir)
 }
 
 func setupLogger() {
@@ -3,8 +3,10 @@ module example.com/jcfg
 go 1.15
 
 require (
+	github.com/google/go-jsonnet v0.17.0
 	github.com/mitchellh/mapstructure v1.4.1
 	github.com/pkg/errors v0.9.1
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/sirupsen/logrus v1.2.0
 	github.com/spf13/cobra v1.1.3
 	golang.org/x/sync v0.0.0-20190423024810-112230192c58
@@ -7,7 +7,10 @@ package api
 
 import (
 	"context"
+	"fmt"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
@@ -16,35 +19,183 @@ type Resource interface {
 	GetKind() string
 	GetName() string
 	GetMetadata() MetadataDef
-	Apply(ctx context.Context, log *logrus.Logger) error
+	Check(ctx context.Context, log *logrus.Logger) (Drift, error)
+	Apply(ctx context.Context, log *logrus.Logger) (Result, error)
 	Fail(*logrus.Logger, error) error
-	Done()
+	Done() error
+	Transition(to Phase, reason string) error
+	Init()
+}
+
+// Describes a single attribute of a resource that differs from the desired
+// state.
+type Change struct {
+	Attribute string `json:"attribute"`      // e.g. content, mode, ownership
+	Message   string `json:"message"`        // Human readable description
+	Diff      string `json:"diff,omitempty"` // Unified diff of content changes
+}
+
+// Returned by the check phase of a resource. Lists every attribute that must
+// change to reach the desired state.
+type Drift struct {
+	Changes []Change
+}
+
+// True if the resource is already in the desired state
+func (d Drift) InSync() bool {
+	return len(d.Changes) == 0
+}
+
+// Returned by the apply phase of a resource
+type Result struct {
+	Changed bool     // Was the system modified to reach the desired state
+	Changes []Change // Attributes that were changed
+	Stdout  string   // Output captured from commands run by the resource
+	Stderr  string
+	Backups []Backup // Content saved before it was replaced or removed
+}
+
+// Content of a file saved to the backup bucket before it was changed
+type Backup struct {
+	Path string `json:"path"`
+	Sum  string `json:"sum"` // SHA-256 of the content, its key in the bucket
 }
 
 type MetadataDef struct {
-	Name        string
-	Description string
-	Annotations map[string]string
-	Ordering    OrderingDef
-	State       StateDef
+	Name              string
+	Description       string
+	Annotations       map[string]string
+	Labels            map[string]string // Key/values to select resources by
+	Ordering          OrderingDef
+	ConcurrencyGroups []string // Resources sharing a group never apply at once
+	Retry             RetryDef
+	State             StateDef
 }
 
+// Ordering between resources, referenced by <Kind>::<Name> or collected by a
+// selector such as kind=Exec,label:module=yum
 type OrderingDef struct {
-	//	Before []string // should be pointer to resource. How? <resource>::<name>
-	AfterOk   []string // should be pointer to resource. How?
-	AfterFail []string // should be pointer to resource. How?
+	AfterOk   []string // Apply after these succeed
+	AfterFail []string // Apply after these fail
+	Before    []string // These apply after this succeeds
+	Notify    []string // As Before, and refresh these if this changed
+	Subscribe []string // As AfterOk, and refresh this if any of these changed
+}
+
+// Retrying a resource whose apply failed. Delays are go durations, e.g. 10s.
+type RetryDef struct {
+	Attempts  int     // Total tries, including the first. 0 or 1 never retries
+	Delay     string  // Wait before the first retry
+	Backoff   float64 // Delay multiplier after each retry, 1 if unset
+	MaxDelay  string  // Cap on the delay, none if unset
+	ExitCodes []int   // If set, only retry commands exiting w/ these codes
+}
+
+// Parse the initial and max delays. Unset delays are zero.
+func (r RetryDef) Delays() (time.Duration, time.Duration, error) {
+	var delay, maxDelay time.Duration
+	var err error
+	if r.Delay != "" {
+		if delay, err = time.ParseDuration(r.Delay); err != nil {
+			return 0, 0, errors.Errorf("Unable to parse retry delay %s: %v", r.Delay, err)
+		}
+	}
+	if r.MaxDelay != "" {
+		if maxDelay, err = time.ParseDuration(r.MaxDelay); err != nil {
+			return 0, 0, errors.Errorf(
+				"Unable to parse retry max delay %s: %v", r.MaxDelay, err,
+			)
+		}
+	}
+	return delay, maxDelay, nil
+}
+
+// Implemented by resources that can react to a change in a resource notifying
+// them, e.g. restarting a service after its config changed. Refresh is called
+// after Apply.
+type Refresher interface {
+	Refresh(ctx context.Context, log *logrus.Logger) (Result, error)
+}
+
+// Returned by Apply when a resource decides at runtime that it shouldn't run.
+// The resource is moved to the skipped phase rather than failed.
+type SkipError struct {
+	Reason string
+}
+
+func (e *SkipError) Error() string {
+	return "skipped: " + e.Reason
+}
+
+// Returned when a command exits w/ a code other than expected, so the engine
+// can tell which failures a retry policy covers
+type ExitCodeError struct {
+	Code     int
+	Expected []int
+}
+
+func (e *ExitCodeError) Error() string {
+	if len(e.Expected) == 1 {
+		return fmt.Sprintf("Exit code %d != expected %d", e.Code, e.Expected[0])
+	}
+	return fmt.Sprintf("Exit code %d not in expected %v", e.Code, e.Expected)
+}
+
+// Phases a resource moves through while a graph is applied. Every resource
+// starts pending and must end in one of the terminal phases: completed,
+// failed, skipped or cancelled.
+type Phase string
+
+const (
+	PhasePending   Phase = "pending"
+	PhaseRunning   Phase = "running"
+	PhaseCompleted Phase = "completed"
+	PhaseFailed    Phase = "failed"
+	PhaseSkipped   Phase = "skipped"
+	PhaseCancelled Phase = "cancelled"
+)
+
+// Allowed transitions between phases. Terminal phases have none.
+var phaseTransitions = map[Phase][]Phase{
+	PhasePending: {PhaseRunning, PhaseSkipped, PhaseCancelled},
+	PhaseRunning: {PhaseCompleted, PhaseFailed, PhaseSkipped, PhaseCancelled},
 }
 
 type StateDef struct {
-	Completed bool
-	Failed    bool
+	Phase  Phase
+	Reason string // Why the resource failed, was skipped or cancelled
+}
+
+// True once the resource has reached a final phase
+func (s StateDef) Terminal() bool {
+	switch s.Phase {
+	case PhaseCompleted, PhaseFailed, PhaseSkipped, PhaseCancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+// Move to phase to, recording why. Returns an error if the state machine
+// doesn't allow the transition.
+func (s *StateDef) Transition(to Phase, reason string) error {
+	for _, allowed := range phaseTransitions[s.Phase] {
+		if allowed == to {
+			s.Phase = to
+			s.Reason = reason
+			return nil
+		}
+	}
+	return errors.Errorf("Invalid state transition from %s to %s", s.Phase, to)
 }
 
 // Struct defining supported sources for content.
 type ContentSpec struct {
-	Type        string // Name of source to use. Used as key in this struct.
-	String      string // Source file content from this string.
-	LocalSource string // Source file content from file with this path on host.
+	Type        string       // Name of source to use. Used as key in this struct.
+	String      string       // Source file content from this string.
+	LocalSource string       // Source file content from file with this path on host.
+	Output      OutputRef    // Source content from a value published this apply.
+	Template    TemplateSpec // Render content from a Go text/template.
 	Secret      SecretSpec
 	HTTPSource  HTTPSourceSpec
 }
@@ -52,8 +203,13 @@ type ContentSpec struct {
 type SecretSpec struct {
 }
 
-// Empty stub for now.
+// Content downloaded over HTTP(S), pinned by SHA-256
 type HTTPSourceSpec struct {
+	URL      string
+	SHA256   string            // Hex SHA-256 the content must have. Required.
+	CABundle string            // PEM file of CAs to trust in place of the system's
+	Headers  map[string]string // Extra request headers, e.g. Authorization
+	Timeout  string            // Whole request, e.g. 30s. Defaults to 1m.
 }
 
 type UserIdentifierSpec struct {
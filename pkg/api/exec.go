@@ -2,17 +2,52 @@ package api
 
 // Definition of exec resource
 type ExecSpec struct {
-	Path     string             // File path of this resource
-	Args     []string           // Array of args to pass to exec
-	Env      []EnvSpec          // Array of env vars to pass
-	Dir      string             // CWD to set
-	UserID   UserIdentifierSpec // User/group to run ass
-	Timeout  string             // Time.Duration in string for exec timeout
-	ExitCode int                // Expected exit code
-	FailOk   bool               // Are resource failures okay? Will mark resource 'completed' instead of 'failed
+	Path        string             // File path of this resource
+	Args        []string           // Array of args to pass to exec
+	Env         []EnvSpec          // Array of env vars to pass
+	Dir         string             // CWD to set
+	UserID      UserIdentifierSpec // User/group to run ass
+	Timeout     string             // Time.Duration in string for exec timeout
+	ExitCode    int                // Expected exit code
+	ExitCodes   []int              // Acceptable exit codes, overrides ExitCode if set
+	Stdout      OutputExpectation  // Expectations on stdout
+	Stderr      OutputExpectation  // Expectations on stderr
+	FailOk      bool               // Are resource failures okay? Will mark resource 'completed' instead of 'failed
+	RefreshOnly bool               // Only run when refreshed by a notify/subscribe
+	Creates     string             // Don't run if this path exists
+	OnlyIf      []string           // Only run if this command exits zero
+	Unless      []string           // Don't run if this command exits zero
+	Outputs     []ExecOutput       // Values to publish after a successful run
+	OutputLimit int                // Bytes of each output stream kept, 1MiB if unset
+}
+
+// A named value published from the output of a command. By default the whole
+// output, trimmed of surrounding whitespace.
+type ExecOutput struct {
+	Name   string // Name consumers reference the value by
+	Stream string // stdout or stderr, stdout if unset
+	Regex  string // Publish a capture group of the first match of this regexp
+	Group  int    // Capture group of Regex to publish, 0 for the whole match
+	JSON   string // Publish the value at this path in the output as json
 }
 
 type EnvSpec struct {
 	Name  string      // Name of env var to set
 	Value ContentSpec // Content of env var
 }
+
+// Expectations on the output of a command, all of which must match for the
+// command to succeed. Unset fields aren't checked.
+type OutputExpectation struct {
+	Exact    *string           // Output equals this, ignoring trailing newlines
+	Contains string            // Output contains this
+	Regex    string            // Output matches this regexp
+	JSON     []JSONExpectation // Output parses as json w/ these values
+}
+
+// Expects the value at a path in json output. Paths are dot separated object
+// keys and array indexes, e.g. status.units.0.name
+type JSONExpectation struct {
+	Path  string
+	Value interface{}
+}
@@ -8,4 +8,5 @@ type FileSpec struct {
 	Mode    string      // String of 4 digit unix permissions mode
 	Target  string      // If ensure is link, set link target
 	Content ContentSpec // Struct to define content
+	NoDiff  bool        // Never show content diffs, e.g. for secrets
 }
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"context"
+	"sync"
+
+	"example.com/jcfg/pkg/facts"
+)
+
+// A Go text/template to render content from, inline or from a file on host
+type TemplateSpec struct {
+	Inline string // Template text
+	Source string // Path of a file holding the template text
+}
+
+// What templates are rendered w/, besides the resource itself: catalog vars,
+// and facts about the node. Facts are only gathered once a template asks for
+// them. Safe for concurrent use.
+type TemplateEnv struct {
+	Vars   map[string]interface{}
+	gather func() (*facts.Facts, error)
+	once   sync.Once
+	facts  *facts.Facts
+	err    error
+}
+
+func NewTemplateEnv(
+	vars map[string]interface{}, gather func() (*facts.Facts, error),
+) *TemplateEnv {
+	if vars == nil {
+		vars = map[string]interface{}{}
+	}
+	return &TemplateEnv{Vars: vars, gather: gather}
+}
+
+// Facts about the node, gathered on first use
+func (e *TemplateEnv) Facts() (*facts.Facts, error) {
+	e.once.Do(func() {
+		e.facts, e.err = e.gather()
+	})
+	return e.facts, e.err
+}
+
+type templateEnvKey struct{}
+
+// Attach a template env to ctx for resources applied under it
+func WithTemplateEnv(ctx context.Context, e *TemplateEnv) context.Context {
+	return context.WithValue(ctx, templateEnvKey{}, e)
+}
+
+// Get the template env attached to ctx, or nil if there isn't one
+func TemplateEnvFrom(ctx context.Context) *TemplateEnv {
+	e, _ := ctx.Value(templateEnvKey{}).(*TemplateEnv)
+	return e
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"sync"
+)
+
+// Values published by resources while a graph applies, for later resources to
+// read through an output content source. Safe for concurrent use.
+type ValueStore struct {
+	lock   sync.RWMutex
+	values map[OutputRef]string
+}
+
+// References a named output of a resource, <Kind>::<Name>
+type OutputRef struct {
+	Resource string // Key of the resource publishing the output
+	Name     string // Name of the output
+}
+
+func (o OutputRef) String() string {
+	return o.Resource + "/" + o.Name
+}
+
+// Implemented by resources that publish named outputs
+type Producer interface {
+	Produces() []string
+}
+
+// Implemented by resources that read outputs published by other resources.
+// The graph checks every output consumed is produced by a resource ordered
+// before the consumer.
+type Consumer interface {
+	Consumes() []OutputRef
+}
+
+func NewValueStore() *ValueStore {
+	return &ValueStore{values: make(map[OutputRef]string)}
+}
+
+// Publish a value, replacing any published under the same ref
+func (s *ValueStore) Set(ref OutputRef, value string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.values[ref] = value
+}
+
+// Read a published value, returning false if there isn't one
+func (s *ValueStore) Get(ref OutputRef) (string, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	value, ok := s.values[ref]
+	return value, ok
+}
+
+type valueStoreKey struct{}
+
+// Attach a value store to ctx for resources applied under it
+func WithValueStore(ctx context.Context, s *ValueStore) context.Context {
+	return context.WithValue(ctx, valueStoreKey{}, s)
+}
+
+// Get the value store attached to ctx, or nil if there isn't one
+func ValueStoreFrom(ctx context.Context) *ValueStore {
+	s, _ := ctx.Value(valueStoreKey{}).(*ValueStore)
+	return s
+}
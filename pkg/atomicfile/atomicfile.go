@@ -0,0 +1,75 @@
+// main file for atomicfile package
+
+// The atomicfile package replaces files atomically, so readers only ever see
+// the old content or the new, never a partial write, even after a crash.
+//
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// Who should own a written file
+type Owner struct {
+	Uid uint32
+	Gid uint32
+}
+
+// Write data to fp atomically w/ mode. The file is owned by owner, or by the
+// user jcfg runs as if owner is nil.
+//
+// The data goes to a temp file in the same directory, w/ the final mode and
+// owner already set, which is synced and renamed over fp. The directory is
+// synced too so the rename survives a crash. Concurrent writers of the same
+// path each rename a complete file into place, the last one winning.
+func Write(fp string, data []byte, mode os.FileMode, owner *Owner) (err error) {
+	dir := filepath.Dir(fp)
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(fp)+".jcfg-")
+	if err != nil {
+		return errors.Errorf("Unable to create temp file in %s: %v", dir, err)
+	}
+	tmpPath := tmp.Name()
+	// Don't leave the temp file behind if anything fails before the rename
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		return errors.Errorf("Unable to write temp file %s: %v", tmpPath, err)
+	}
+	// Chown first, as it can clear setuid/setgid bits
+	if owner != nil {
+		if err = tmp.Chown(int(owner.Uid), int(owner.Gid)); err != nil {
+			return errors.Errorf("Unable to chown temp file %s: %v", tmpPath, err)
+		}
+	}
+	if err = tmp.Chmod(mode); err != nil {
+		return errors.Errorf("Unable to chmod temp file %s: %v", tmpPath, err)
+	}
+	if err = tmp.Sync(); err != nil {
+		return errors.Errorf("Unable to sync temp file %s: %v", tmpPath, err)
+	}
+	if err = tmp.Close(); err != nil {
+		return errors.Errorf("Unable to close temp file %s: %v", tmpPath, err)
+	}
+	if err = os.Rename(tmpPath, fp); err != nil {
+		return errors.Errorf("Unable to rename %s to %s: %v", tmpPath, fp, err)
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return errors.Errorf("Unable to open directory %s to sync: %v", dir, err)
+	}
+	defer d.Close()
+	if err = d.Sync(); err != nil {
+		return errors.Errorf("Unable to sync directory %s: %v", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteReplaces(t *testing.T) {
+	dir := t.TempDir()
+	fp := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(fp, []byte("old\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(fp, []byte("new\n"), 0640, nil); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	data, err := ioutil.ReadFile(fp)
+	if err != nil || string(data) != "new\n" {
+		t.Errorf("file holds %q, %v", data, err)
+	}
+	if fi, err := os.Stat(fp); err != nil || fi.Mode().Perm() != 0640 {
+		t.Errorf("mode is %v, expected 0640 (%v)", fi.Mode(), err)
+	}
+	// Nothing's left behind next to it
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("expected only the file in %s, got %d entries (%v)", dir, len(entries), err)
+	}
+}
+
+func TestWriteCleansUpOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	// A directory can't be renamed over by a file
+	fp := filepath.Join(dir, "sub")
+	if err := os.Mkdir(fp, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(fp, []byte("data"), 0600, nil); err == nil {
+		t.Fatalf("Write replaced a directory")
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("temp file left behind in %s (%d entries, %v)", dir, len(entries), err)
+	}
+}
@@ -0,0 +1,179 @@
+// main file for bucket package
+
+// The bucket package stores backups of file content, addressed by the SHA-256
+// of the content, so earlier versions of managed files can be recovered.
+//
+package bucket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"example.com/jcfg/pkg/atomicfile"
+	"github.com/pkg/errors"
+)
+
+// Where backups are kept unless told otherwise
+const DefaultDir = "/var/lib/jcfg/bucket"
+
+// A single backup of a file. The same content backed up from several paths,
+// or several times, is stored once.
+type Entry struct {
+	Sum  string      `json:"sum"` // Hex SHA-256 of the content
+	Path string      `json:"path"`
+	Time time.Time   `json:"time"`
+	Size int64       `json:"size"`
+	Mode os.FileMode `json:"mode"`
+	Uid  uint32      `json:"uid"`
+	Gid  uint32      `json:"gid"`
+}
+
+// Content addressed store under a directory. Content lives at
+// <dir>/<first 2 chars of sum>/<sum>, and every backup is appended to
+// <dir>/index.jsonl. Safe for concurrent use within a process.
+type Bucket struct {
+	Dir  string
+	lock sync.Mutex // Guards the index
+}
+
+func New(dir string) *Bucket {
+	return &Bucket{Dir: dir}
+}
+
+func (b *Bucket) contentPath(sum string) string {
+	return filepath.Join(b.Dir, sum[:2], sum)
+}
+
+func (b *Bucket) indexPath() string {
+	return filepath.Join(b.Dir, "index.jsonl")
+}
+
+// Back up the current content of the regular file at fp
+func (b *Bucket) Backup(fp string) (Entry, error) {
+	fi, err := os.Lstat(fp)
+	if err != nil {
+		return Entry{}, errors.Errorf("Unable to lstat %s: %v", fp, err)
+	}
+	if !fi.Mode().IsRegular() {
+		return Entry{}, errors.Errorf("Unable to back up %s, not a regular file", fp)
+	}
+	data, err := ioutil.ReadFile(fp)
+	if err != nil {
+		return Entry{}, errors.Errorf("Unable to read %s: %v", fp, err)
+	}
+	sum := sha256.Sum256(data)
+	entry := Entry{
+		Sum:  hex.EncodeToString(sum[:]),
+		Path: fp,
+		Time: time.Now().UTC(),
+		Size: int64(len(data)),
+		Mode: fi.Mode().Perm(),
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		entry.Uid = st.Uid
+		entry.Gid = st.Gid
+	}
+
+	// Store the content, unless we already have it
+	cp := b.contentPath(entry.Sum)
+	if _, err := os.Stat(cp); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(cp), 0700); err != nil {
+			return Entry{}, errors.Errorf("Unable to create bucket dir: %v", err)
+		}
+		if err := atomicfile.Write(cp, data, 0600, nil); err != nil {
+			return Entry{}, errors.Errorf("Unable to store backup of %s: %v", fp, err)
+		}
+	} else if err != nil {
+		return Entry{}, errors.Errorf("Unable to stat backup %s: %v", cp, err)
+	}
+
+	// Record the backup
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return Entry{}, errors.Errorf("Unable to marshal bucket entry: %v", err)
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	index, err := os.OpenFile(
+		b.indexPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600,
+	)
+	if err != nil {
+		return Entry{}, errors.Errorf("Unable to open bucket index: %v", err)
+	}
+	defer index.Close()
+	if _, err := index.Write(append(line, '\n')); err != nil {
+		return Entry{}, errors.Errorf("Unable to write bucket index: %v", err)
+	}
+	return entry, nil
+}
+
+// List backups of fp, oldest first. An empty fp lists every backup.
+func (b *Bucket) List(fp string) ([]Entry, error) {
+	data, err := ioutil.ReadFile(b.indexPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Errorf("Unable to read bucket index: %v", err)
+	}
+	var entries []Entry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, errors.Errorf("Unable to parse bucket index: %v", err)
+		}
+		if fp == "" || entry.Path == fp {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Errorf("Unable to read bucket index: %v", err)
+	}
+	return entries, nil
+}
+
+// Find the backup of fp w/ sum, or its latest backup if sum is empty
+func (b *Bucket) Find(fp string, sum string) (Entry, error) {
+	entries, err := b.List(fp)
+	if err != nil {
+		return Entry{}, err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if sum == "" || entries[i].Sum == sum {
+			return entries[i], nil
+		}
+	}
+	if sum == "" {
+		return Entry{}, errors.Errorf("No backups of %s in bucket", fp)
+	}
+	return Entry{}, errors.Errorf("No backup of %s w/ sum %s in bucket", fp, sum)
+}
+
+// Read the content of a backup, checking it still matches its sum
+func (b *Bucket) Content(entry Entry) ([]byte, error) {
+	if len(entry.Sum) != sha256.Size*2 {
+		return nil, errors.Errorf("Invalid sum %q", entry.Sum)
+	}
+	data, err := ioutil.ReadFile(b.contentPath(entry.Sum))
+	if err != nil {
+		return nil, errors.Errorf("Unable to read backup %s: %v", entry.Sum, err)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != entry.Sum {
+		return nil, errors.Errorf("Backup %s is corrupt", entry.Sum)
+	}
+	return data, nil
+}
@@ -0,0 +1,151 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Adjacency list of a loaded graph, for tools that want the ordering w/o
+// parsing DOT
+type Adjacency struct {
+	Nodes []AdjacencyNode `json:"nodes"`
+	Edges []AdjacencyEdge `json:"edges"`
+}
+
+// A resource in the graph. Outcome is only set if a report was given.
+type AdjacencyNode struct {
+	Key     string  `json:"key"`
+	Api     string  `json:"api"`
+	Kind    string  `json:"kind"`
+	Name    string  `json:"name"`
+	Outcome Outcome `json:"outcome,omitempty"`
+}
+
+// An edge from the resource that goes first to the one waiting on it
+type AdjacencyEdge struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Relation  string `json:"relation"`
+	Collected bool   `json:"collected,omitempty"` // Expanded from a selector
+}
+
+// DOT attributes of an edge, by relation
+var dotEdgeStyles = map[relation]string{
+	relAfterOk:   `color="darkgreen"`,
+	relAfterFail: `color="red", style="dashed"`,
+	relBefore:    `color="black"`,
+	relNotify:    `color="blue", style="bold"`,
+	relSubscribe: `color="blue", style="bold"`,
+}
+
+// DOT fill colour of a node, by outcome in a report
+var dotNodeColours = map[Outcome]string{
+	OutcomeUnchanged: "palegreen",
+	OutcomeChanged:   "gold",
+	OutcomeFailed:    "salmon",
+	OutcomeSkipped:   "lightgrey",
+	OutcomeCancelled: "grey",
+}
+
+// Outcome of every resource in a report, by key. A nil report gives nil.
+func reportOutcomes(report *Report) map[string]Outcome {
+	if report == nil {
+		return nil
+	}
+	outcomes := make(map[string]Outcome, len(report.Resources))
+	for _, rr := range report.Resources {
+		outcomes[rr.Key] = rr.Outcome
+	}
+	return outcomes
+}
+
+// Build the adjacency list of the loaded graph, w/ nodes and edges in catalog
+// order. Outcomes are taken from report if it isn't nil.
+func (g *Graph) Adjacency(report *Report) Adjacency {
+	outcomes := reportOutcomes(report)
+	adj := Adjacency{
+		Nodes: []AdjacencyNode{},
+		Edges: []AdjacencyEdge{},
+	}
+	for _, r := range g.ResourceList {
+		n := g.nodes[resourceKey(r)]
+		adj.Nodes = append(adj.Nodes, AdjacencyNode{
+			Key:     n.key,
+			Api:     r.GetApi(),
+			Kind:    r.GetKind(),
+			Name:    r.GetMetadata().Name,
+			Outcome: outcomes[n.key],
+		})
+		for _, e := range n.children {
+			adj.Edges = append(adj.Edges, AdjacencyEdge{
+				From:      e.parent.key,
+				To:        e.child.key,
+				Relation:  e.rel.String(),
+				Collected: e.collected,
+			})
+		}
+	}
+	return adj
+}
+
+// Write the adjacency list of the loaded graph to w as json
+func (g *Graph) WriteJSON(w io.Writer, report *Report) error {
+	data, err := json.MarshalIndent(g.Adjacency(report), "", "  ")
+	if err != nil {
+		return errors.Errorf("Unable to marshal graph: %v", err)
+	}
+	if _, err := fmt.Fprintf(w, "%s\n", data); err != nil {
+		return errors.Errorf("Unable to write graph: %v", err)
+	}
+	return nil
+}
+
+// Write the loaded graph to w in Graphviz DOT. Edges are styled by relation
+// and, if report isn't nil, nodes are filled by their outcome in it.
+func (g *Graph) WriteDot(w io.Writer, report *Report) error {
+	outcomes := reportOutcomes(report)
+	var b strings.Builder
+	b.WriteString("digraph jcfg {\n")
+	b.WriteString("  rankdir=\"LR\";\n")
+	b.WriteString("  node [shape=\"box\"];\n")
+	for _, r := range g.ResourceList {
+		key := resourceKey(r)
+		if colour, ok := dotNodeColours[outcomes[key]]; ok {
+			fmt.Fprintf(
+				&b, "  %s [style=\"filled\", fillcolor=%s];\n",
+				dotQuote(key), dotQuote(colour),
+			)
+		} else {
+			fmt.Fprintf(&b, "  %s;\n", dotQuote(key))
+		}
+	}
+	for _, r := range g.ResourceList {
+		for _, e := range g.nodes[resourceKey(r)].children {
+			label := e.rel.String()
+			if e.collected {
+				label += " (collected)"
+			}
+			fmt.Fprintf(
+				&b, "  %s -> %s [label=%s, %s];\n",
+				dotQuote(e.parent.key), dotQuote(e.child.key), dotQuote(label),
+				dotEdgeStyles[e.rel],
+			)
+		}
+	}
+	b.WriteString("}\n")
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return errors.Errorf("Unable to write graph: %v", err)
+	}
+	return nil
+}
+
+// Quote s as a DOT string
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
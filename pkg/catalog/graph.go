@@ -0,0 +1,908 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/jcfg/pkg/api"
+	"example.com/jcfg/pkg/facts"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+type Graph struct {
+	Catalog
+	ResourceMap map[string]*api.Resource
+	Noop        bool // Report what would change instead of applying
+	Parallelism int  // Max resources applying at once, 0 for no limit
+	nodes       map[string]*node
+	limit       *limiter
+	statusLock  sync.Mutex // Guards the status of every node
+}
+
+// A node wraps a single resource in the graph. Its done channel is closed once
+// the node's goroutine has exited, after which the resource state is safe to
+// read from any other goroutine.
+type node struct {
+	key      string
+	resource api.Resource
+	parents  []edge
+	children []edge
+	done     chan struct{}
+	outcome  Outcome       // What happened to the resource during apply
+	result   api.Result    // Changes made, or that would be made in noop mode
+	err      string        // Error the resource failed with, if any
+	duration time.Duration // Time spent checking or applying the resource
+	attempts []Attempt     // Tries made, if the resource has a retry policy
+	status   nodeStatus    // Live view of the node, guarded by statusLock
+}
+
+// Where a node is while the graph is applying. Mirrors the resource phase so
+// it can be read from other goroutines without racing the resource.
+type nodeStatus struct {
+	phase     api.Phase
+	waitingOn string    // What a pending node is blocked on
+	since     time.Time // When the node entered this status
+}
+
+// The per-resource outcome of applying a graph
+type Outcome string
+
+const (
+	OutcomeUnchanged Outcome = "unchanged"
+	OutcomeChanged   Outcome = "changed"
+	OutcomeFailed    Outcome = "failed"
+	OutcomeSkipped   Outcome = "skipped"
+	OutcomeCancelled Outcome = "cancelled"
+)
+
+// Relations a resource can declare against another in its ordering
+type relation int
+
+const (
+	relAfterOk relation = iota
+	relAfterFail
+	relBefore
+	relNotify
+	relSubscribe
+)
+
+func (rel relation) String() string {
+	switch rel {
+	case relAfterOk:
+		return "afterOk"
+	case relAfterFail:
+		return "afterFail"
+	case relBefore:
+		return "before"
+	case relNotify:
+		return "notify"
+	case relSubscribe:
+		return "subscribe"
+	default:
+		return "unknown"
+	}
+}
+
+// Does a change in the parent trigger a refresh of the child
+func (rel relation) refreshes() bool {
+	return rel == relNotify || rel == relSubscribe
+}
+
+// An edge points from a parent to a child that must wait on it
+type edge struct {
+	parent    *node
+	child     *node
+	rel       relation
+	collected bool // Created by expanding a selector rather than a key
+}
+
+// Build the key used to index a resource in the graph, <Kind>::<Name>
+func resourceKey(r api.Resource) string {
+	return strings.Title(r.GetKind()) + "::" + r.GetMetadata().Name
+}
+
+// Decision made by a child once a parent has exited
+type verdict int
+
+const (
+	verdictRun verdict = iota
+	verdictSkip
+	verdictCancel
+)
+
+// Wait for the parent of edge e to exit, and decide from its final phase
+// whether the child should run. Returns the verdict and the reason for it.
+//
+// Before, notify and subscribe all order like afterOk. Edges collected from a
+// selector treat a skipped parent as done rather than skipping the child: a
+// collection is satisfied once every member that needed to run has.
+//
+//	parent      afterOk  afterFail
+//	completed   run      skip
+//	failed      skip     run
+//	skipped     skip     skip
+//	cancelled   cancel   cancel
+func waitParent(
+	ctx context.Context, n *node, e edge, log *logrus.Logger,
+) (verdict, string) {
+	key := n.key
+	p := e.parent.key
+	log.Debugf("Checking if parent %s was applied before applying %s\n", p, key)
+	// Block until the parent exits or we're cancelled
+	select {
+	case <-ctx.Done():
+		return verdictCancel, "context cancelled"
+	case <-e.parent.done:
+	}
+	ps := e.parent.resource.GetMetadata().State
+	switch ps.Phase {
+	case api.PhaseCompleted:
+		if e.rel == relAfterFail {
+			return verdictSkip, fmt.Sprintf("%s parent %s completed", e.rel, p)
+		}
+	case api.PhaseFailed:
+		if e.rel != relAfterFail {
+			return verdictSkip, fmt.Sprintf("%s parent %s failed", e.rel, p)
+		}
+	case api.PhaseSkipped:
+		if !e.collected || e.rel == relAfterFail {
+			return verdictSkip, fmt.Sprintf("parent %s was skipped", p)
+		}
+	case api.PhaseCancelled:
+		return verdictCancel, fmt.Sprintf("parent %s was cancelled", p)
+	default:
+		// Shouldn't happen, applyResource guarantees a terminal phase on exit
+		return verdictCancel, fmt.Sprintf(
+			"parent %s exited in non-terminal phase %s", p, ps.Phase,
+		)
+	}
+	log.Debugf("%s: Parent %s %s, continuing\n", key, p, ps.Phase)
+	return verdictRun, ""
+}
+
+func applyResource(
+	ctx context.Context,
+	g *Graph, n *node,
+	log *logrus.Logger,
+	wg *sync.WaitGroup,
+	errChan chan error,
+) {
+
+	// Defer our clean up. Closing done wakes any children waiting on us
+	defer wg.Done()
+	defer close(n.done)
+
+	// Helper vars
+	r := n.resource
+	key := n.key
+
+	// Publish our final phase once we're done
+	defer func() {
+		g.setStatus(n, r.GetMetadata().State.Phase, "")
+	}()
+
+	// Whatever happens, never exit without a terminal phase or our children
+	// can't decide whether to run
+	defer func() {
+		if state := r.GetMetadata().State; !state.Terminal() {
+			n.outcome = OutcomeFailed
+			err := r.Fail(log, errors.Errorf(
+				"%s: exited in non-terminal phase %s", key, state.Phase,
+			))
+			if err != nil {
+				errChan <- err
+			}
+		}
+	}()
+
+	// Wait for parents to exit, bailing on the first that says not to run
+	for _, e := range n.parents {
+		g.setStatus(n, api.PhasePending, e.parent.key)
+		v, reason := waitParent(ctx, n, e, log)
+		switch v {
+		case verdictSkip:
+			n.outcome = OutcomeSkipped
+			log.Infof("%s: skipped, %s\n", key, reason)
+			if err := r.Transition(api.PhaseSkipped, reason); err != nil {
+				errChan <- errors.Errorf("%s: Unable to skip: %v", key, err)
+			}
+			return
+		case verdictCancel:
+			cancelResource(n, reason, log, errChan)
+			return
+		}
+	}
+	// Wait our turn behind the parallelism limit and our concurrency groups
+	waitingOn := "a parallelism slot"
+	if groups := r.GetMetadata().ConcurrencyGroups; len(groups) != 0 {
+		waitingOn = "concurrency group(s) " + strings.Join(groups, ", ")
+	}
+	g.setStatus(n, api.PhasePending, waitingOn)
+	release, err := g.limit.acquire(ctx, n)
+	if err != nil {
+		cancelResource(n, "context cancelled", log, errChan)
+		return
+	}
+	defer release()
+	if ctx.Err() != nil {
+		cancelResource(n, "context cancelled", log, errChan)
+		return
+	}
+	if err := r.Transition(api.PhaseRunning, ""); err != nil {
+		errChan <- errors.Errorf("%s: Unable to start: %v", key, err)
+		return
+	}
+	g.setStatus(n, api.PhaseRunning, "")
+
+	if g.Noop {
+		noopResource(ctx, n, log, errChan)
+		return
+	}
+
+	log.Infof("%s: start applying\n", key)
+	// Apply our state
+	started := time.Now()
+	result, err := applyAndRefresh(ctx, n, log)
+	n.duration = time.Since(started)
+	n.result = result
+	if ctx.Err() != nil {
+		cancelResource(n, "context cancelled while applying", log, errChan)
+		return
+	}
+	var skipErr *api.SkipError
+	if errors.As(err, &skipErr) {
+		n.outcome = OutcomeSkipped
+		log.Infof("%s: skipped, %s\n", key, skipErr.Reason)
+		if err := r.Transition(api.PhaseSkipped, skipErr.Reason); err != nil {
+			errChan <- errors.Errorf("%s: Unable to skip: %v", key, err)
+		}
+		return
+	}
+	if err != nil {
+		n.outcome = OutcomeFailed
+		n.err = err.Error()
+		err = r.Fail(log, errors.Errorf(
+			"%s: Unable to apply resource: %s", key, err,
+		))
+		if err != nil {
+			errChan <- err
+		}
+		return
+	}
+
+	n.outcome = OutcomeUnchanged
+	if result.Changed {
+		n.outcome = OutcomeChanged
+	}
+	if err := r.Done(); err != nil {
+		errChan <- errors.Errorf("%s: Unable to complete: %v", key, err)
+		return
+	}
+	log.Infof("%s: applied, %s\n", key, n.outcome)
+	return
+}
+
+// List the parents notifying n that changed. Only safe to call once all of
+// n's parents are done.
+func notifiedBy(n *node) []string {
+	var by []string
+	for _, e := range n.parents {
+		if e.rel.refreshes() && e.parent.outcome == OutcomeChanged {
+			by = append(by, e.parent.key)
+		}
+	}
+	return by
+}
+
+// Apply the resource, then refresh it if any resource notifying it changed.
+// Resources without a refresh action ignore notifications.
+func applyAndRefresh(
+	ctx context.Context, n *node, log *logrus.Logger,
+) (api.Result, error) {
+	r := n.resource
+	result, err := withRetry(ctx, n, "apply", log, func() (api.Result, error) {
+		return r.Apply(ctx, log)
+	})
+	if err != nil {
+		return result, err
+	}
+	by := notifiedBy(n)
+	if len(by) == 0 {
+		return result, nil
+	}
+	refresher, ok := r.(api.Refresher)
+	if !ok {
+		log.Debugf("%s: notified by %v, but can't refresh\n", n.key, by)
+		return result, nil
+	}
+	log.Infof("%s: refreshing, notified by %s\n", n.key, strings.Join(by, ", "))
+	refreshed, err := withRetry(ctx, n, "refresh", log, func() (api.Result, error) {
+		return refresher.Refresh(ctx, log)
+	})
+	result.Changed = true
+	result.Changes = append(result.Changes, api.Change{
+		Attribute: "refresh",
+		Message:   "refreshed after change to " + strings.Join(by, ", "),
+	})
+	result.Stdout += refreshed.Stdout
+	result.Stderr += refreshed.Stderr
+	if err != nil {
+		return result, errors.Errorf("Unable to refresh: %v", err)
+	}
+	return result, nil
+}
+
+// Move a resource to the cancelled phase
+func cancelResource(
+	n *node, reason string, log *logrus.Logger, errChan chan error,
+) {
+	n.outcome = OutcomeCancelled
+	log.Warnf("%s: cancelled, %s\n", n.key, reason)
+	if err := n.resource.Transition(api.PhaseCancelled, reason); err != nil {
+		errChan <- errors.Errorf("%s: Unable to cancel: %v", n.key, err)
+	}
+}
+
+// Record what applying the resource would change. Resources that report
+// successfully are marked completed, so the ordering of their children is
+// simulated as if every change applied cleanly.
+func noopResource(
+	ctx context.Context, n *node, log *logrus.Logger, errChan chan error,
+) {
+	r := n.resource
+	key := n.key
+	log.Infof("%s: checking for changes\n", key)
+	// Nothing publishes outputs in noop mode, so resources reading them can't
+	// be checked
+	if unknown := unpublished(ctx, r); len(unknown) != 0 {
+		n.result = api.Result{Changes: []api.Change{{
+			Attribute: "output",
+			Message: "reads " + strings.Join(unknown, ", ") +
+				", not known until applied",
+		}}}
+		n.outcome = OutcomeChanged
+		if err := r.Done(); err != nil {
+			errChan <- errors.Errorf("%s: Unable to complete: %v", key, err)
+			return
+		}
+		log.Infof("%s: checked, %s\n", key, n.outcome)
+		return
+	}
+	started := time.Now()
+	drift, err := r.Check(ctx, log)
+	n.duration = time.Since(started)
+	if err != nil {
+		n.outcome = OutcomeFailed
+		n.err = err.Error()
+		err = r.Fail(log, errors.Errorf(
+			"%s: Unable to check resource: %s", key, err,
+		))
+		if err != nil {
+			errChan <- err
+		}
+		return
+	}
+	n.result = api.Result{Changes: drift.Changes}
+	if by := notifiedBy(n); len(by) != 0 {
+		if _, ok := r.(api.Refresher); ok {
+			n.result.Changes = append(n.result.Changes, api.Change{
+				Attribute: "refresh",
+				Message:   "will refresh after change to " + strings.Join(by, ", "),
+			})
+		}
+	}
+	n.outcome = OutcomeUnchanged
+	if len(n.result.Changes) != 0 {
+		n.outcome = OutcomeChanged
+	}
+	if err := r.Done(); err != nil {
+		errChan <- errors.Errorf("%s: Unable to complete: %v", key, err)
+		return
+	}
+	log.Infof("%s: checked, %s\n", key, n.outcome)
+}
+
+func (g *Graph) Apply(ctx context.Context, log *logrus.Logger) error {
+
+	log.Debugf("Applying catalog\n")
+	log.Debugf("Catalog contents: %+v\n", g.ResourceMap)
+
+	// Outputs published by resources only live for this apply
+	ctx = api.WithValueStore(ctx, api.NewValueStore())
+	// Templates read catalog vars, and facts gathered once for the apply
+	ctx = api.WithTemplateEnv(ctx, api.NewTemplateEnv(g.Vars, facts.Gather))
+
+	// Reset the per-apply node state
+	for _, n := range g.nodes {
+		n.done = make(chan struct{})
+		n.outcome = ""
+		n.result = api.Result{}
+		n.err = ""
+		n.duration = 0
+		n.attempts = nil
+	}
+	g.limit = newLimiter(g.Parallelism, g.nodes)
+	for _, n := range g.nodes {
+		g.setStatus(n, api.PhasePending, "")
+	}
+
+	// Spawn each resource to apply in a separate goroutine
+	// Each routine blocks on the done channels of the resources it relies on
+	// until it's ready to apply
+	errChan := make(chan error)
+	resourceApplyWG := &sync.WaitGroup{}
+	for _, r := range g.ResourceList {
+		resourceApplyWG.Add(1)
+		go applyResource(ctx, g, g.nodes[resourceKey(r)], log, resourceApplyWG, errChan)
+	}
+
+	// Spin up go routine to handle errors from the resource apply go routines
+	var errorHandlerWG sync.WaitGroup
+	errorHandlerWG.Add(1)
+	go func() {
+		defer errorHandlerWG.Done()
+		for err := range errChan {
+			log.Errorf("%v\n", err)
+		}
+	}()
+
+	// Wait for resource go routines to exit
+	resourceApplyWG.Wait()
+	// Close the error channel, ending the error handler go routine
+	close(errChan)
+	// Wait for error handler to exit
+	errorHandlerWG.Wait()
+
+	log.Infoln("Applied catalog")
+	failed := 0
+	for _, n := range g.nodes {
+		if n.resource.GetMetadata().State.Phase == api.PhaseFailed {
+			failed++
+		}
+	}
+	if failed != 0 {
+		return errors.Errorf("%d resource(s) failed", failed)
+	}
+	if ctx.Err() != nil {
+		return errors.Errorf("Apply cancelled: %v", ctx.Err())
+	}
+	return nil
+}
+
+func (g *Graph) setStatus(n *node, phase api.Phase, waitingOn string) {
+	g.statusLock.Lock()
+	defer g.statusLock.Unlock()
+	n.status = nodeStatus{phase: phase, waitingOn: waitingOn, since: time.Now()}
+}
+
+// Write which resources are running, and which are waiting and on what. Safe
+// to call while the graph is applying.
+func (g *Graph) WriteStatus(w io.Writer) error {
+	g.statusLock.Lock()
+	defer g.statusLock.Unlock()
+	var running, waiting []string
+	finished := 0
+	for _, r := range g.ResourceList {
+		n := g.nodes[resourceKey(r)]
+		elapsed := time.Since(n.status.since).Round(time.Second)
+		switch n.status.phase {
+		case api.PhaseRunning:
+			running = append(running, fmt.Sprintf("%s (%s)", n.key, elapsed))
+		case api.PhasePending:
+			on := n.status.waitingOn
+			if on == "" {
+				on = "nothing yet"
+			}
+			waiting = append(waiting, fmt.Sprintf(
+				"%s on %s (%s)", n.key, on, elapsed,
+			))
+		default:
+			finished++
+		}
+	}
+	out := "Running:\n"
+	for _, line := range running {
+		out += "  " + line + "\n"
+	}
+	out += "Waiting:\n"
+	for _, line := range waiting {
+		out += "  " + line + "\n"
+	}
+	out += fmt.Sprintf(
+		"Finished %d of %d resources\n", finished, len(g.ResourceList),
+	)
+	if _, err := io.WriteString(w, out); err != nil {
+		return errors.Errorf("Unable to write status: %v", err)
+	}
+	return nil
+}
+
+// Write a summary of a noop apply to w, one resource at a time in catalog
+// order w/ the changes it would make.
+func (g *Graph) WriteNoop(w io.Writer) error {
+	summaries := map[Outcome]string{
+		OutcomeUnchanged: "in sync",
+		OutcomeChanged:   "would change",
+		OutcomeFailed:    "failed",
+		OutcomeSkipped:   "would be skipped",
+		OutcomeCancelled: "cancelled",
+	}
+	for _, r := range g.ResourceList {
+		n := g.nodes[resourceKey(r)]
+		summary := summaries[n.outcome]
+		if _, err := fmt.Fprintf(w, "%s: %s\n", n.key, summary); err != nil {
+			return errors.Errorf("Unable to write noop summary: %v", err)
+		}
+		if n.outcome == OutcomeFailed && n.err != "" {
+			fmt.Fprintf(w, "  error: %s\n", strings.ReplaceAll(
+				strings.TrimSuffix(n.err, "\n"), "\n", "\n    ",
+			))
+		}
+		for _, c := range n.result.Changes {
+			fmt.Fprintf(w, "  %s: %s\n", c.Attribute, c.Message)
+			if c.Diff != "" {
+				fmt.Fprintf(w, "    %s\n", strings.ReplaceAll(
+					strings.TrimSuffix(c.Diff, "\n"), "\n", "\n    ",
+				))
+			}
+		}
+	}
+	return nil
+}
+
+// Load a catalog into the graph. Every resource is indexed by key and its
+// ordering is resolved into edges between nodes. The whole graph is validated
+// before returning: all unknown references and all cycles are reported
+// together, so a bad catalog fails before any resource is touched.
+func (g *Graph) LoadCatalog(c *Catalog, log *logrus.Logger) error {
+	g.ResourceList = c.ResourceList
+	g.Vars = c.Vars
+	g.ResourceMap = make(map[string]*api.Resource)
+	g.nodes = make(map[string]*node)
+	for index := range c.ResourceList {
+		key := resourceKey(c.ResourceList[index])
+		if _, keyAlreadySet := g.ResourceMap[key]; keyAlreadySet {
+			return errors.Errorf(
+				"Key %s already set in graph. Check catalog for duplicated resources",
+				key,
+			)
+		}
+		g.ResourceMap[key] = &c.ResourceList[index]
+		g.nodes[key] = &node{key: key, resource: c.ResourceList[index]}
+		log.Debugf("Added resource %s to resourceMap\n", key)
+	}
+
+	var problems []string
+	for _, r := range g.ResourceList {
+		for _, p := range validateRetry(r.GetMetadata().Retry) {
+			problems = append(problems, resourceKey(r)+": "+p)
+		}
+	}
+	problems = append(problems, g.resolveEdges(log)...)
+	problems = append(problems, g.checkOutputs()...)
+	problems = append(problems, g.findCycles()...)
+	if len(problems) != 0 {
+		return errors.Errorf(
+			"Catalog failed validation with %d problem(s):\n  %s",
+			len(problems), strings.Join(problems, "\n  "),
+		)
+	}
+	log.Debugf("Validated graph of %d resources\n", len(g.nodes))
+	return nil
+}
+
+// Resolve the ordering of every resource into edges. Returns a description of
+// every reference that doesn't point at a resource in the graph.
+func (g *Graph) resolveEdges(log *logrus.Logger) []string {
+	var problems []string
+	for _, r := range g.ResourceList {
+		n := g.nodes[resourceKey(r)]
+		ordering := r.GetMetadata().Ordering
+		// Before and notify are declared on the parent, the rest on the child
+		relations := []struct {
+			rel      relation
+			refs     []string
+			onParent bool
+		}{
+			{relAfterOk, ordering.AfterOk, false},
+			{relAfterFail, ordering.AfterFail, false},
+			{relBefore, ordering.Before, true},
+			{relNotify, ordering.Notify, true},
+			{relSubscribe, ordering.Subscribe, false},
+		}
+		for _, rels := range relations {
+			for _, ref := range rels.refs {
+				others, collected, err := g.resolveRef(n, ref)
+				if err != nil {
+					problems = append(problems, fmt.Sprintf(
+						"%s: %s %v", n.key, rels.rel, err,
+					))
+					continue
+				}
+				for _, other := range others {
+					parent, child := other, n
+					if rels.onParent {
+						parent, child = n, other
+					}
+					g.addEdge(parent, child, rels.rel, collected)
+					log.Debugf(
+						"Added edge %s -%s-> %s\n", parent.key, rels.rel, child.key,
+					)
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// Prune the graph down to the resources matching any of selectors or keys,
+// plus every resource they transitively depend on if withDeps is set.
+// Ordering against resources left out of the selection is dropped, so they're
+// treated as already applied.
+func (g *Graph) Select(
+	selectors []*Selector, keys []string, withDeps bool, log *logrus.Logger,
+) error {
+	keep := make(map[*node]bool)
+	for _, key := range keys {
+		n, ok := g.nodes[key]
+		if !ok {
+			return errors.Errorf("Unable to find resource %s in graph", key)
+		}
+		keep[n] = true
+	}
+	for _, sel := range selectors {
+		matched := false
+		for _, r := range g.ResourceList {
+			if sel.Matches(r) {
+				keep[g.nodes[resourceKey(r)]] = true
+				matched = true
+			}
+		}
+		if !matched {
+			return errors.Errorf("Selector %s matches no resources", sel)
+		}
+	}
+
+	if withDeps {
+		var visit func(n *node)
+		visit = func(n *node) {
+			for _, e := range n.parents {
+				if !keep[e.parent] {
+					keep[e.parent] = true
+					visit(e.parent)
+				}
+			}
+		}
+		selected := make([]*node, 0, len(keep))
+		for n := range keep {
+			selected = append(selected, n)
+		}
+		for _, n := range selected {
+			visit(n)
+		}
+	}
+
+	// Rebuild the graph w/ only the kept resources, in catalog order
+	var rl []api.Resource
+	for _, r := range g.ResourceList {
+		if keep[g.nodes[resourceKey(r)]] {
+			rl = append(rl, r)
+		}
+	}
+	g.ResourceList = rl
+	g.ResourceMap = make(map[string]*api.Resource)
+	nodes := make(map[string]*node)
+	for index := range rl {
+		key := resourceKey(rl[index])
+		g.ResourceMap[key] = &rl[index]
+		nodes[key] = g.nodes[key]
+	}
+	g.nodes = nodes
+	for _, n := range g.nodes {
+		var parents, children []edge
+		for _, e := range n.parents {
+			if keep[e.parent] {
+				parents = append(parents, e)
+			} else {
+				log.Debugf("%s: dropping %s on unselected %s\n", n.key, e.rel, e.parent.key)
+			}
+		}
+		for _, e := range n.children {
+			if keep[e.child] {
+				children = append(children, e)
+			}
+		}
+		n.parents, n.children = parents, children
+	}
+	log.Infof("Selected %d resources to apply\n", len(rl))
+	return nil
+}
+
+// Resolve an ordering reference to nodes. A reference is either a resource
+// key, <Kind>::<Name>, or a selector such as kind=Exec,label:module=yum that
+// collects every matching resource other than n itself. Returns whether the
+// reference was a selector.
+func (g *Graph) resolveRef(n *node, ref string) ([]*node, bool, error) {
+	if other, ok := g.nodes[ref]; ok {
+		return []*node{other}, false, nil
+	}
+	if !strings.Contains(ref, "=") {
+		return nil, false, errors.Errorf("references unknown resource %s", ref)
+	}
+	sel, err := ParseSelector(ref)
+	if err != nil {
+		return nil, true, errors.Errorf("has invalid selector: %v", err)
+	}
+	var matched []*node
+	for _, r := range g.ResourceList {
+		if other := g.nodes[resourceKey(r)]; other != n && sel.Matches(r) {
+			matched = append(matched, other)
+		}
+	}
+	if len(matched) == 0 {
+		return nil, true, errors.Errorf("selector %s matches no resources", ref)
+	}
+	return matched, true, nil
+}
+
+func (g *Graph) addEdge(
+	parent *node, child *node, rel relation, collected bool,
+) {
+	e := edge{parent: parent, child: child, rel: rel, collected: collected}
+	child.parents = append(child.parents, e)
+	parent.children = append(parent.children, e)
+}
+
+// Check every output a resource reads is produced by a resource it's ordered
+// after, so the value is always published before it's read
+func (g *Graph) checkOutputs() []string {
+	var problems []string
+	for _, r := range g.ResourceList {
+		consumer, ok := r.(api.Consumer)
+		if !ok {
+			continue
+		}
+		n := g.nodes[resourceKey(r)]
+		var ancestors map[*node]bool
+		for _, ref := range consumer.Consumes() {
+			producer, ok := g.nodes[ref.Resource]
+			if !ok {
+				problems = append(problems, fmt.Sprintf(
+					"%s: reads output %s of unknown resource %s",
+					n.key, ref, ref.Resource,
+				))
+				continue
+			}
+			if !produces(producer.resource, ref.Name) {
+				problems = append(problems, fmt.Sprintf(
+					"%s: reads output %s, which %s doesn't produce",
+					n.key, ref, producer.key,
+				))
+				continue
+			}
+			if ancestors == nil {
+				ancestors = n.ancestors()
+			}
+			if !ancestors[producer] {
+				problems = append(problems, fmt.Sprintf(
+					"%s: reads output %s, but isn't ordered after %s",
+					n.key, ref, producer.key,
+				))
+			}
+		}
+	}
+	return problems
+}
+
+// Does r produce the named output
+func produces(r api.Resource, name string) bool {
+	producer, ok := r.(api.Producer)
+	if !ok {
+		return false
+	}
+	for _, produced := range producer.Produces() {
+		if produced == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Collect every node n waits on, directly or through other nodes
+func (n *node) ancestors() map[*node]bool {
+	seen := make(map[*node]bool)
+	queue := []*node{n}
+	for len(queue) != 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, e := range cur.parents {
+			if !seen[e.parent] {
+				seen[e.parent] = true
+				queue = append(queue, e.parent)
+			}
+		}
+	}
+	return seen
+}
+
+// List the outputs r reads that haven't been published to the value store in
+// ctx
+func unpublished(ctx context.Context, r api.Resource) []string {
+	consumer, ok := r.(api.Consumer)
+	if !ok {
+		return nil
+	}
+	store := api.ValueStoreFrom(ctx)
+	var unknown []string
+	for _, ref := range consumer.Consumes() {
+		if store != nil {
+			if _, ok := store.Get(ref); ok {
+				continue
+			}
+		}
+		unknown = append(unknown, ref.String())
+	}
+	return unknown
+}
+
+// Walk the graph depth first from every node, reporting each cycle found as
+// the full path of keys around it.
+func (g *Graph) findCycles() []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	var problems []string
+	color := make(map[*node]int)
+	var path []*node
+	var visit func(n *node)
+	visit = func(n *node) {
+		color[n] = visiting
+		path = append(path, n)
+		for _, e := range n.children {
+			switch color[e.child] {
+			case unvisited:
+				visit(e.child)
+			case visiting:
+				// Back edge - the cycle is the path from the child to here
+				var keys []string
+				for i := len(path) - 1; i >= 0; i-- {
+					keys = append([]string{path[i].key}, keys...)
+					if path[i] == e.child {
+						break
+					}
+				}
+				keys = append(keys, e.child.key)
+				problems = append(problems, fmt.Sprintf(
+					"cycle detected: %s", strings.Join(keys, " -> "),
+				))
+			}
+		}
+		path = path[:len(path)-1]
+		color[n] = visited
+	}
+	for _, r := range g.ResourceList {
+		if n := g.nodes[resourceKey(r)]; color[n] == unvisited {
+			visit(n)
+		}
+	}
+	return problems
+}
+
+func (g *Graph) FetchResource(
+	resourceName string, log *logrus.Logger,
+) (*api.Resource, error) {
+	val, ok := g.ResourceMap[resourceName]
+	if !ok {
+		return nil, errors.Errorf(
+			"Unable to find resource %s in graph", resourceName,
+		)
+	}
+	return val, nil
+}
@@ -0,0 +1,379 @@
+package catalog
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/jcfg/pkg/api"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// A resource that records when it applies, and fails or blocks if told to
+type stub struct {
+	Metadata  api.MetadataDef
+	fail      bool
+	unchanged bool          // Apply w/o reporting a change
+	block     bool          // Block in Apply until ctx is cancelled
+	started   chan struct{} // Closed once Apply starts, if set
+	refreshed bool          // Set once Refresh is called
+	gauges    []*gauge      // Held for a moment while applying
+	rec       *recorder
+}
+
+// Order resources applied in, shared by the stubs of a test
+type recorder struct {
+	lock    sync.Mutex
+	applied []string
+}
+
+func (r *recorder) add(key string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.applied = append(r.applied, key)
+}
+
+func (r *recorder) index(key string) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for i, k := range r.applied {
+		if k == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// Tracks how many stubs are applying at once, and the most there ever were
+type gauge struct {
+	lock    sync.Mutex
+	current int
+	max     int
+}
+
+func (g *gauge) enter() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.current++
+	if g.current > g.max {
+		g.max = g.current
+	}
+}
+
+func (g *gauge) exit() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.current--
+}
+
+func (g *gauge) peak() int {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.max
+}
+
+func (s *stub) GetApi() string               { return "v1" }
+func (s *stub) GetKind() string              { return "Stub" }
+func (s *stub) GetName() string              { return "Stub::" + s.Metadata.Name }
+func (s *stub) GetMetadata() api.MetadataDef { return s.Metadata }
+func (s *stub) Done() error                  { return s.Transition(api.PhaseCompleted, "") }
+func (s *stub) Init() {
+	s.Metadata.State = api.StateDef{Phase: api.PhasePending}
+}
+
+func (s *stub) Transition(to api.Phase, reason string) error {
+	return s.Metadata.State.Transition(to, reason)
+}
+
+func (s *stub) Fail(log *logrus.Logger, err error) error {
+	if tErr := s.Transition(api.PhaseFailed, err.Error()); tErr != nil {
+		return errors.Errorf("%v: %v", err, tErr)
+	}
+	return err
+}
+
+func (s *stub) Check(ctx context.Context, log *logrus.Logger) (api.Drift, error) {
+	if s.fail {
+		return api.Drift{}, errors.Errorf("check failed")
+	}
+	return api.Drift{}, nil
+}
+
+func (s *stub) Apply(ctx context.Context, log *logrus.Logger) (api.Result, error) {
+	if s.started != nil {
+		close(s.started)
+	}
+	if s.block {
+		<-ctx.Done()
+		return api.Result{}, ctx.Err()
+	}
+	for _, g := range s.gauges {
+		g.enter()
+	}
+	time.Sleep(time.Duration(len(s.gauges)) * 20 * time.Millisecond)
+	for _, g := range s.gauges {
+		g.exit()
+	}
+	s.rec.add(s.GetName())
+	if s.fail {
+		return api.Result{}, errors.Errorf("apply failed")
+	}
+	return api.Result{Changed: !s.unchanged}, nil
+}
+
+func (s *stub) Refresh(ctx context.Context, log *logrus.Logger) (api.Result, error) {
+	s.refreshed = true
+	return api.Result{Changed: true}, nil
+}
+
+func testLogger() *logrus.Logger {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	return log
+}
+
+// Build a stub named name, ordered by ordering
+func newStub(rec *recorder, name string, ordering api.OrderingDef) *stub {
+	s := &stub{rec: rec}
+	s.Metadata.Name = name
+	s.Metadata.Ordering = ordering
+	s.Init()
+	return s
+}
+
+func loadGraph(t *testing.T, stubs ...*stub) *Graph {
+	t.Helper()
+	c := &Catalog{}
+	for _, s := range stubs {
+		c.ResourceList = append(c.ResourceList, s)
+	}
+	g := &Graph{}
+	if err := g.LoadCatalog(c, testLogger()); err != nil {
+		t.Fatalf("LoadCatalog: %v", err)
+	}
+	return g
+}
+
+func expectPhase(t *testing.T, s *stub, want api.Phase) {
+	t.Helper()
+	if got := s.Metadata.State.Phase; got != want {
+		t.Errorf("%s is %s, expected %s (%s)", s.GetName(), got, want,
+			s.Metadata.State.Reason)
+	}
+}
+
+func TestAfterOkOrdering(t *testing.T) {
+	rec := &recorder{}
+	a := newStub(rec, "a", api.OrderingDef{})
+	b := newStub(rec, "b", api.OrderingDef{AfterOk: []string{"Stub::a"}})
+	c := newStub(rec, "c", api.OrderingDef{AfterOk: []string{"Stub::b"}})
+	// Declared out of order, so only the edges can order them
+	g := loadGraph(t, c, b, a)
+	if err := g.Apply(context.Background(), testLogger()); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	for _, s := range []*stub{a, b, c} {
+		expectPhase(t, s, api.PhaseCompleted)
+	}
+	if !(rec.index("Stub::a") < rec.index("Stub::b") &&
+		rec.index("Stub::b") < rec.index("Stub::c")) {
+		t.Errorf("applied in order %v, expected a, b, c", rec.applied)
+	}
+}
+
+func TestAfterFail(t *testing.T) {
+	rec := &recorder{}
+	a := newStub(rec, "a", api.OrderingDef{})
+	a.fail = true
+	onFail := newStub(rec, "onFail", api.OrderingDef{AfterFail: []string{"Stub::a"}})
+	g := loadGraph(t, a, onFail)
+	if err := g.Apply(context.Background(), testLogger()); err == nil {
+		t.Errorf("Apply succeeded w/ a failed resource")
+	}
+	expectPhase(t, a, api.PhaseFailed)
+	expectPhase(t, onFail, api.PhaseCompleted)
+	if rec.index("Stub::onFail") < rec.index("Stub::a") {
+		t.Errorf("applied in order %v, expected onFail after a", rec.applied)
+	}
+}
+
+func TestLoadCatalogRejectsBadGraphs(t *testing.T) {
+	tests := []struct {
+		name  string
+		stubs func(rec *recorder) []*stub
+		want  []string
+	}{
+		{
+			name: "cycle",
+			stubs: func(rec *recorder) []*stub {
+				return []*stub{
+					newStub(rec, "a", api.OrderingDef{AfterOk: []string{"Stub::c"}}),
+					newStub(rec, "b", api.OrderingDef{AfterOk: []string{"Stub::a"}}),
+					newStub(rec, "c", api.OrderingDef{AfterOk: []string{"Stub::b"}}),
+				}
+			},
+			want: []string{"cycle detected", "Stub::a", "Stub::b", "Stub::c"},
+		},
+		{
+			name: "unknown ref",
+			stubs: func(rec *recorder) []*stub {
+				return []*stub{
+					newStub(rec, "a", api.OrderingDef{AfterOk: []string{"Stub::nope"}}),
+					newStub(rec, "b", api.OrderingDef{AfterFail: []string{"Stub::gone"}}),
+					newStub(rec, "c", api.OrderingDef{Before: []string{"Stub::lost"}}),
+				}
+			},
+			want: []string{
+				"3 problem(s)", "references unknown resource Stub::nope",
+				"references unknown resource Stub::gone",
+				"Stub::c: before references unknown resource Stub::lost",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Catalog{}
+			for _, s := range tt.stubs(&recorder{}) {
+				c.ResourceList = append(c.ResourceList, s)
+			}
+			g := &Graph{}
+			err := g.LoadCatalog(c, testLogger())
+			if err == nil {
+				t.Fatalf("LoadCatalog accepted a bad graph")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q doesn't mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAfterFailSkippedWhenParentCompletes(t *testing.T) {
+	rec := &recorder{}
+	a := newStub(rec, "a", api.OrderingDef{})
+	onFail := newStub(rec, "onFail", api.OrderingDef{AfterFail: []string{"Stub::a"}})
+	g := loadGraph(t, a, onFail)
+	if err := g.Apply(context.Background(), testLogger()); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	expectPhase(t, a, api.PhaseCompleted)
+	expectPhase(t, onFail, api.PhaseSkipped)
+}
+
+func TestSkipPropagatesThroughChain(t *testing.T) {
+	rec := &recorder{}
+	a := newStub(rec, "a", api.OrderingDef{})
+	a.fail = true
+	b := newStub(rec, "b", api.OrderingDef{AfterOk: []string{"Stub::a"}})
+	c := newStub(rec, "c", api.OrderingDef{AfterOk: []string{"Stub::b"}})
+	// A skipped parent skips afterFail children too, only failures trigger them
+	d := newStub(rec, "d", api.OrderingDef{AfterFail: []string{"Stub::b"}})
+	g := loadGraph(t, a, b, c, d)
+	g.Apply(context.Background(), testLogger())
+	expectPhase(t, a, api.PhaseFailed)
+	for _, s := range []*stub{b, c, d} {
+		expectPhase(t, s, api.PhaseSkipped)
+	}
+	if len(rec.applied) != 1 {
+		t.Errorf("applied %v, expected only a", rec.applied)
+	}
+}
+
+func TestCancelMovesPendingToCancelled(t *testing.T) {
+	rec := &recorder{}
+	a := newStub(rec, "a", api.OrderingDef{})
+	a.block = true
+	a.started = make(chan struct{})
+	b := newStub(rec, "b", api.OrderingDef{AfterOk: []string{"Stub::a"}})
+	c := newStub(rec, "c", api.OrderingDef{AfterOk: []string{"Stub::b"}})
+	g := loadGraph(t, a, b, c)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-a.started
+		cancel()
+	}()
+	if err := g.Apply(ctx, testLogger()); err == nil {
+		t.Errorf("Apply succeeded after being cancelled")
+	}
+	for _, s := range []*stub{a, b, c} {
+		expectPhase(t, s, api.PhaseCancelled)
+	}
+	if len(rec.applied) != 0 {
+		t.Errorf("applied %v after cancelling", rec.applied)
+	}
+}
+
+func TestBeforeOrdering(t *testing.T) {
+	rec := &recorder{}
+	// Declared on the resource that goes first
+	a := newStub(rec, "a", api.OrderingDef{Before: []string{"Stub::b"}})
+	b := newStub(rec, "b", api.OrderingDef{})
+	g := loadGraph(t, b, a)
+	if err := g.Apply(context.Background(), testLogger()); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if rec.index("Stub::a") > rec.index("Stub::b") {
+		t.Errorf("applied in order %v, expected a before b", rec.applied)
+	}
+}
+
+func TestNotifyRefreshesOnlyOnChange(t *testing.T) {
+	tests := []struct {
+		name      string
+		unchanged bool
+		refresh   bool
+	}{
+		{name: "changed", refresh: true},
+		{name: "unchanged", unchanged: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &recorder{}
+			unit := newStub(rec, "unit", api.OrderingDef{Notify: []string{"Stub::service"}})
+			unit.unchanged = tt.unchanged
+			service := newStub(rec, "service", api.OrderingDef{})
+			conf := newStub(rec, "conf", api.OrderingDef{})
+			conf.unchanged = tt.unchanged
+			watcher := newStub(rec, "watcher", api.OrderingDef{Subscribe: []string{"Stub::conf"}})
+			g := loadGraph(t, unit, service, conf, watcher)
+			if err := g.Apply(context.Background(), testLogger()); err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+			if service.refreshed != tt.refresh {
+				t.Errorf("notified service refreshed: %v, expected %v", service.refreshed, tt.refresh)
+			}
+			if watcher.refreshed != tt.refresh {
+				t.Errorf("subscribed watcher refreshed: %v, expected %v", watcher.refreshed, tt.refresh)
+			}
+			if rec.index("Stub::unit") > rec.index("Stub::service") {
+				t.Errorf("applied in order %v, expected unit before service", rec.applied)
+			}
+		})
+	}
+}
+
+func TestNoopReportsCheckError(t *testing.T) {
+	rec := &recorder{}
+	a := newStub(rec, "a", api.OrderingDef{})
+	a.fail = true
+	g := loadGraph(t, a)
+	g.Noop = true
+	g.Apply(context.Background(), testLogger())
+	var out strings.Builder
+	if err := g.WriteNoop(&out); err != nil {
+		t.Fatalf("WriteNoop: %v", err)
+	}
+	if !strings.Contains(out.String(), "error: check failed") {
+		t.Errorf("noop output missing the check error:\n%s", out.String())
+	}
+	if len(rec.applied) != 0 {
+		t.Errorf("applied %v in noop mode", rec.applied)
+	}
+}
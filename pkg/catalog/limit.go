@@ -0,0 +1,71 @@
+package catalog
+
+import (
+	"context"
+	"sort"
+)
+
+// Bounds how many resources apply at once. Holds a global pool of slots and
+// a lock per concurrency group, all as buffered channels so waiting can be
+// cancelled through the context.
+type limiter struct {
+	slots  chan struct{}            // nil if parallelism is unbounded
+	groups map[string]chan struct{} // One slot per named group
+}
+
+// Build a limiter allowing parallelism resources at once, 0 for no limit,
+// w/ a lock for every group used in the graph
+func newLimiter(parallelism int, nodes map[string]*node) *limiter {
+	l := &limiter{groups: make(map[string]chan struct{})}
+	if parallelism > 0 {
+		l.slots = make(chan struct{}, parallelism)
+	}
+	for _, n := range nodes {
+		for _, group := range n.resource.GetMetadata().ConcurrencyGroups {
+			if _, ok := l.groups[group]; !ok {
+				l.groups[group] = make(chan struct{}, 1)
+			}
+		}
+	}
+	return l
+}
+
+// Block until the node holds every one of its groups and a slot. Groups are
+// always taken in sorted order so two nodes sharing groups can't deadlock.
+// Returns a func to release everything, or the context error if cancelled
+// while waiting.
+func (l *limiter) acquire(ctx context.Context, n *node) (func(), error) {
+	groups := append([]string{}, n.resource.GetMetadata().ConcurrencyGroups...)
+	sort.Strings(groups)
+	var held []chan struct{}
+	release := func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			<-held[i]
+		}
+	}
+	take := func(c chan struct{}) error {
+		select {
+		case c <- struct{}{}:
+			held = append(held, c)
+			return nil
+		case <-ctx.Done():
+			release()
+			return ctx.Err()
+		}
+	}
+	for i, group := range groups {
+		// Skip duplicates, we'd deadlock on ourselves
+		if i > 0 && groups[i-1] == group {
+			continue
+		}
+		if err := take(l.groups[group]); err != nil {
+			return nil, err
+		}
+	}
+	if l.slots != nil {
+		if err := take(l.slots); err != nil {
+			return nil, err
+		}
+	}
+	return release, nil
+}
@@ -0,0 +1,97 @@
+package catalog
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"example.com/jcfg/pkg/api"
+)
+
+func TestConcurrencyGroupSerializes(t *testing.T) {
+	rec := &recorder{}
+	rpmdb, all := &gauge{}, &gauge{}
+	var stubs []*stub
+	for _, spec := range []struct {
+		name   string
+		groups []string
+	}{
+		{"a", []string{"rpmdb"}},
+		// Duplicated and unsorted groups mustn't deadlock
+		{"b", []string{"rpmdb", "rpmdb"}},
+		{"c", []string{"zypp", "rpmdb"}},
+		{"d", []string{"rpmdb", "zypp"}},
+	} {
+		s := newStub(rec, spec.name, api.OrderingDef{})
+		s.Metadata.ConcurrencyGroups = spec.groups
+		s.gauges = []*gauge{rpmdb, all}
+		stubs = append(stubs, s)
+	}
+	free := newStub(rec, "free", api.OrderingDef{})
+	free.gauges = []*gauge{all}
+	g := loadGraph(t, append(stubs, free)...)
+	if err := g.Apply(context.Background(), testLogger()); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if n := rpmdb.peak(); n != 1 {
+		t.Errorf("%d resources sharing a group applied at once", n)
+	}
+	// The rest of the graph stays parallel
+	if n := all.peak(); n < 2 {
+		t.Errorf("resource w/o a group never applied alongside the group")
+	}
+	if len(rec.applied) != 5 {
+		t.Errorf("applied %v, expected every resource", rec.applied)
+	}
+}
+
+func TestParallelismOneSerializes(t *testing.T) {
+	rec := &recorder{}
+	all := &gauge{}
+	var stubs []*stub
+	for _, name := range []string{"a", "b", "c", "d"} {
+		s := newStub(rec, name, api.OrderingDef{})
+		s.gauges = []*gauge{all}
+		stubs = append(stubs, s)
+	}
+	g := loadGraph(t, stubs...)
+	g.Parallelism = 1
+	if err := g.Apply(context.Background(), testLogger()); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if n := all.peak(); n != 1 {
+		t.Errorf("%d independent resources applied at once w/ parallelism 1", n)
+	}
+}
+
+func TestAcquireReleasesOnCancel(t *testing.T) {
+	rec := &recorder{}
+	holder := newStub(rec, "holder", api.OrderingDef{})
+	holder.Metadata.ConcurrencyGroups = []string{"b"}
+	waiter := newStub(rec, "waiter", api.OrderingDef{})
+	waiter.Metadata.ConcurrencyGroups = []string{"a", "b"}
+	other := newStub(rec, "other", api.OrderingDef{})
+	other.Metadata.ConcurrencyGroups = []string{"a"}
+	g := loadGraph(t, holder, waiter, other)
+	l := newLimiter(0, g.nodes)
+
+	release, err := l.acquire(context.Background(), g.nodes["Stub::holder"])
+	if err != nil {
+		t.Fatalf("acquire holder: %v", err)
+	}
+	defer release()
+	// The waiter takes a, then blocks on b until cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := l.acquire(ctx, g.nodes["Stub::waiter"]); err == nil {
+		t.Fatalf("acquired a group held by another resource")
+	}
+	// So a must be free again
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	releaseOther, err := l.acquire(ctx, g.nodes["Stub::other"])
+	if err != nil {
+		t.Fatalf("group still held after a cancelled acquire: %v", err)
+	}
+	releaseOther()
+}
@@ -0,0 +1,108 @@
+package catalog
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"example.com/jcfg/pkg/api"
+	"github.com/pkg/errors"
+)
+
+// Machine readable summary of applying a graph
+type Report struct {
+	Noop      bool             `json:"noop"`
+	Summary   ReportSummary    `json:"summary"`
+	Resources []ResourceReport `json:"resources"`
+}
+
+// Counts of resources by final phase, plus how many changed
+type ReportSummary struct {
+	Total     int `json:"total"`
+	Changed   int `json:"changed"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+	Skipped   int `json:"skipped"`
+	Cancelled int `json:"cancelled"`
+}
+
+// Final state of a single resource
+type ResourceReport struct {
+	Key      string       `json:"key"`
+	State    api.Phase    `json:"state"`
+	Reason   string       `json:"reason,omitempty"`
+	Outcome  Outcome      `json:"outcome"`
+	Changed  bool         `json:"changed"`
+	Duration float64      `json:"duration"` // Seconds spent applying
+	Attempts []Attempt    `json:"attempts,omitempty"`
+	Error    string       `json:"error,omitempty"`
+	Changes  []api.Change `json:"changes,omitempty"`
+	Stdout   string       `json:"stdout,omitempty"`
+	Stderr   string       `json:"stderr,omitempty"`
+	Backups  []api.Backup `json:"backups,omitempty"`
+}
+
+// Build a report of the last apply, w/ resources in catalog order. Only safe
+// to call once Apply has returned.
+func (g *Graph) Report() Report {
+	report := Report{Noop: g.Noop}
+	for _, r := range g.ResourceList {
+		n := g.nodes[resourceKey(r)]
+		state := r.GetMetadata().State
+		rr := ResourceReport{
+			Key:      n.key,
+			State:    state.Phase,
+			Reason:   state.Reason,
+			Outcome:  n.outcome,
+			Changed:  n.outcome == OutcomeChanged,
+			Duration: n.duration.Seconds(),
+			Attempts: n.attempts,
+			Error:    n.err,
+			Changes:  n.result.Changes,
+			Stdout:   n.result.Stdout,
+			Stderr:   n.result.Stderr,
+			Backups:  n.result.Backups,
+		}
+		report.Resources = append(report.Resources, rr)
+
+		report.Summary.Total++
+		if rr.Changed {
+			report.Summary.Changed++
+		}
+		switch state.Phase {
+		case api.PhaseCompleted:
+			report.Summary.Completed++
+		case api.PhaseFailed:
+			report.Summary.Failed++
+		case api.PhaseSkipped:
+			report.Summary.Skipped++
+		case api.PhaseCancelled:
+			report.Summary.Cancelled++
+		}
+	}
+	return report
+}
+
+// Write the report of the last apply to fp as json
+func (g *Graph) WriteReport(fp string) error {
+	data, err := json.MarshalIndent(g.Report(), "", "  ")
+	if err != nil {
+		return errors.Errorf("Unable to marshal apply report: %v", err)
+	}
+	if err := ioutil.WriteFile(fp, append(data, '\n'), 0644); err != nil {
+		return errors.Errorf("Unable to write apply report to %s: %v", fp, err)
+	}
+	return nil
+}
+
+// Read back a report written by WriteReport
+func ReadReport(fp string) (*Report, error) {
+	data, err := ioutil.ReadFile(fp)
+	if err != nil {
+		return nil, errors.Errorf("Unable to read apply report %s: %v", fp, err)
+	}
+	report := &Report{}
+	if err := json.Unmarshal(data, report); err != nil {
+		return nil, errors.Errorf("Unable to parse apply report %s: %v", fp, err)
+	}
+	return report, nil
+}
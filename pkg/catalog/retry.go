@@ -0,0 +1,115 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"example.com/jcfg/pkg/api"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// A single try at applying or refreshing a resource
+type Attempt struct {
+	Action   string  `json:"action"` // apply or refresh
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"duration"` // Seconds
+}
+
+// Check a retry policy is usable, returning a problem per bad field
+func validateRetry(retry api.RetryDef) []string {
+	var problems []string
+	if retry.Attempts < 0 {
+		problems = append(problems, fmt.Sprintf(
+			"retry attempts %d is negative", retry.Attempts,
+		))
+	}
+	if retry.Backoff != 0 && retry.Backoff < 1 {
+		problems = append(problems, fmt.Sprintf(
+			"retry backoff %g is less than 1", retry.Backoff,
+		))
+	}
+	if _, _, err := retry.Delays(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	return problems
+}
+
+// How long to wait before retry number retry, counting from 1. The delay
+// grows by the backoff factor each time, up to the max delay if set.
+func retryDelay(policy api.RetryDef, retry int) time.Duration {
+	delay, maxDelay, _ := policy.Delays() // Validated on load
+	backoff := policy.Backoff
+	if backoff == 0 {
+		backoff = 1
+	}
+	wait := float64(delay) * math.Pow(backoff, float64(retry-1))
+	if maxDelay != 0 && wait > float64(maxDelay) {
+		return maxDelay
+	}
+	return time.Duration(wait)
+}
+
+// Is err a failure the retry policy covers. Skips are never retried, and if
+// the policy lists exit codes only commands exiting w/ one of them are.
+func retryable(policy api.RetryDef, err error) bool {
+	var skipErr *api.SkipError
+	if errors.As(err, &skipErr) {
+		return false
+	}
+	if len(policy.ExitCodes) == 0 {
+		return true
+	}
+	var exitErr *api.ExitCodeError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	for _, code := range policy.ExitCodes {
+		if code == exitErr.Code {
+			return true
+		}
+	}
+	return false
+}
+
+// Run action on n, retrying failures as set by the resource's retry policy.
+// When the policy allows retries, every attempt is logged and recorded on n.
+func withRetry(
+	ctx context.Context, n *node, action string, log *logrus.Logger,
+	run func() (api.Result, error),
+) (api.Result, error) {
+	policy := n.resource.GetMetadata().Retry
+	for attempt := 1; ; attempt++ {
+		if policy.Attempts > 1 {
+			log.Infof(
+				"%s: %s attempt %d of %d\n", n.key, action, attempt, policy.Attempts,
+			)
+		}
+		started := time.Now()
+		result, err := run()
+		if policy.Attempts > 1 {
+			a := Attempt{Action: action, Duration: time.Since(started).Seconds()}
+			if err != nil {
+				a.Error = err.Error()
+			}
+			n.attempts = append(n.attempts, a)
+		}
+		if err == nil || attempt >= policy.Attempts || !retryable(policy, err) ||
+			ctx.Err() != nil {
+			return result, err
+		}
+
+		wait := retryDelay(policy, attempt)
+		log.Warnf(
+			"%s: %s attempt %d of %d failed, retrying in %s: %v\n",
+			n.key, action, attempt, policy.Attempts, wait, err,
+		)
+		select {
+		case <-ctx.Done():
+			return result, err
+		case <-time.After(wait):
+		}
+	}
+}
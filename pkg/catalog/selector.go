@@ -0,0 +1,85 @@
+package catalog
+
+import (
+	"strings"
+
+	"example.com/jcfg/pkg/api"
+	"github.com/pkg/errors"
+)
+
+// Matches resources by their fields and labels. Parsed from a comma
+// separated list of terms, all of which must match:
+//
+//	kind=File,label:role=yum
+//
+// Supported terms are api=, kind=, name= and label:<key>=. Kinds are matched
+// case insensitively, everything else exactly.
+type Selector struct {
+	expr  string
+	terms []selectorTerm
+}
+
+type selectorTerm struct {
+	field string // api, kind, name or label
+	label string // Label key if field is label
+	value string
+}
+
+// Parse a selector expression
+func ParseSelector(expr string) (*Selector, error) {
+	s := &Selector{expr: expr}
+	for _, term := range strings.Split(expr, ",") {
+		term = strings.TrimSpace(term)
+		kv := strings.SplitN(term, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, errors.Errorf(
+				"Invalid term %q in selector %q, expected key=value", term, expr,
+			)
+		}
+		t := selectorTerm{field: kv[0], value: kv[1]}
+		switch {
+		case t.field == "api", t.field == "kind", t.field == "name":
+		case strings.HasPrefix(t.field, "label:"):
+			t.label = strings.TrimPrefix(t.field, "label:")
+			t.field = "label"
+			if t.label == "" {
+				return nil, errors.Errorf("Empty label key in selector %q", expr)
+			}
+		default:
+			return nil, errors.Errorf(
+				"Unknown field %q in selector %q, expected api, kind, name or label:<key>",
+				t.field, expr,
+			)
+		}
+		s.terms = append(s.terms, t)
+	}
+	return s, nil
+}
+
+// True if every term matches r
+func (s *Selector) Matches(r api.Resource) bool {
+	md := r.GetMetadata()
+	for _, t := range s.terms {
+		var ok bool
+		switch t.field {
+		case "api":
+			ok = r.GetApi() == t.value
+		case "kind":
+			ok = strings.EqualFold(r.GetKind(), t.value)
+		case "name":
+			ok = md.Name == t.value
+		case "label":
+			var val string
+			val, ok = md.Labels[t.label]
+			ok = ok && val == t.value
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Selector) String() string {
+	return s.expr
+}
@@ -0,0 +1,148 @@
+package catalog
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"example.com/jcfg/pkg/api"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{expr: "kind=File"},
+		{expr: "kind=File, label:role=yum"},
+		{expr: "api=v1,name=a,label:role="},
+		{expr: "label:url=http://x/?a=b"},
+		{expr: "", err: "expected key=value"},
+		{expr: "kind", err: "expected key=value"},
+		{expr: "=File", err: "expected key=value"},
+		{expr: "kind=File,", err: "expected key=value"},
+		{expr: "label:=yum", err: "Empty label key"},
+		{expr: "owner=root", err: `Unknown field "owner"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := ParseSelector(tt.expr)
+			if tt.err == "" && err != nil {
+				t.Errorf("ParseSelector: %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestSelectorMatches(t *testing.T) {
+	s := newStub(&recorder{}, "repo", api.OrderingDef{})
+	s.Metadata.Labels = map[string]string{"role": "yum", "empty": ""}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"kind=Stub", true},
+		{"kind=stub", true},
+		{"kind=File", false},
+		{"api=v1,name=repo", true},
+		{"name=Repo", false},
+		{"label:role=yum", true},
+		{"label:role=Yum", false},
+		{"label:empty=", true},
+		{"label:missing=", false},
+		{"kind=Stub,label:role=apt", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			sel, err := ParseSelector(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseSelector: %v", err)
+			}
+			if got := sel.Matches(s); got != tt.want {
+				t.Errorf("matched %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGraphSelect(t *testing.T) {
+	// repo <- pkg <- svc, and an unrelated other
+	build := func(rec *recorder) []*stub {
+		repo := newStub(rec, "repo", api.OrderingDef{})
+		repo.Metadata.Labels = map[string]string{"role": "yum"}
+		pkg := newStub(rec, "pkg", api.OrderingDef{AfterOk: []string{"Stub::repo"}})
+		svc := newStub(rec, "svc", api.OrderingDef{AfterOk: []string{"Stub::pkg"}})
+		svc.Metadata.Labels = map[string]string{"role": "web"}
+		other := newStub(rec, "other", api.OrderingDef{})
+		return []*stub{repo, pkg, svc, other}
+	}
+	tests := []struct {
+		name      string
+		selectors []string
+		keys      []string
+		withDeps  bool
+		want      []string
+		err       string
+	}{
+		{
+			name: "selector", selectors: []string{"label:role=web"},
+			want: []string{"Stub::svc"},
+		},
+		{
+			name: "deps are transitive", selectors: []string{"label:role=web"},
+			withDeps: true,
+			want:     []string{"Stub::pkg", "Stub::repo", "Stub::svc"},
+		},
+		{
+			name: "keys and selectors", keys: []string{"Stub::other"},
+			selectors: []string{"label:role=yum"}, withDeps: true,
+			want: []string{"Stub::other", "Stub::repo"},
+		},
+		{
+			name: "unknown key", keys: []string{"Stub::nope"},
+			err: "Unable to find resource Stub::nope",
+		},
+		{
+			name: "selector matching nothing", selectors: []string{"label:role=db"},
+			err: "matches no resources",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &recorder{}
+			g := loadGraph(t, build(rec)...)
+			var sels []*Selector
+			for _, expr := range tt.selectors {
+				sel, err := ParseSelector(expr)
+				if err != nil {
+					t.Fatalf("ParseSelector: %v", err)
+				}
+				sels = append(sels, sel)
+			}
+			err := g.Select(sels, tt.keys, tt.withDeps, testLogger())
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Select: %v", err)
+			}
+			// Ordering on unselected resources is dropped, so what's left
+			// applies w/o them
+			if err := g.Apply(context.Background(), testLogger()); err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+			applied := append([]string{}, rec.applied...)
+			sort.Strings(applied)
+			if strings.Join(applied, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("applied %v, expected %v", applied, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+// main file for compiler package
+
+// The compiler package evaluates jsonnet manifests into catalogs. Manifests
+// are free to return nested arrays of resources, which are flattened into the
+// single json array the catalog package expects, or an object of vars and
+// resources for templates to read the vars.
+//
+package compiler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/google/go-jsonnet"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// A compiled manifest. Written out as a bare array of resources unless it has
+// vars.
+type Document struct {
+	Vars      map[string]interface{}   `json:"vars,omitempty"`
+	Resources []map[string]interface{} `json:"resources"`
+}
+
+// The catalog json to write out
+func (d *Document) Catalog() interface{} {
+	if len(d.Vars) == 0 {
+		return d.Resources
+	}
+	return d
+}
+
+// Evaluate the jsonnet manifest at fp, searching jpaths for imports, and
+// return the flattened list of resources it defines, w/ any vars.
+func Compile(
+	fp string, jpaths []string, log *logrus.Logger,
+) (*Document, error) {
+	log.Debugf("Compiling manifest %s w/ jpaths %v\n", fp, jpaths)
+	snippet, err := ioutil.ReadFile(fp)
+	if err != nil {
+		return nil, errors.Errorf("Unable to read manifest %s: %v", fp, err)
+	}
+
+	vm := jsonnet.MakeVM()
+	vm.Importer(&jsonnet.FileImporter{JPaths: jpaths})
+	out, err := vm.EvaluateSnippet(fp, string(snippet))
+	if err != nil {
+		return nil, errors.Errorf("Unable to evaluate manifest %s: %v", fp, err)
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal([]byte(out), &doc); err != nil {
+		return nil, errors.Errorf(
+			"Unable to unmarshal output of manifest %s: %v", fp, err,
+		)
+	}
+	// An object w/ resources and no kind holds vars as well as resources
+	result := &Document{}
+	if obj, ok := doc.(map[string]interface{}); ok {
+		_, hasKind := obj["kind"]
+		if resources, ok := obj["resources"]; ok && !hasKind {
+			vars, ok := obj["vars"].(map[string]interface{})
+			if obj["vars"] != nil && !ok {
+				return nil, errors.Errorf(
+					"Expected vars of manifest %s to be an object", fp,
+				)
+			}
+			result.Vars = vars
+			doc = resources
+		}
+	}
+	result.Resources, err = flatten(doc)
+	if err != nil {
+		return nil, errors.Errorf("Unable to flatten manifest %s: %v", fp, err)
+	}
+	log.Debugf("Compiled %d resources from %s\n", len(result.Resources), fp)
+	return result, nil
+}
+
+// Walk nested arrays depth first, collecting every object in order. Anything
+// other than an array or object can't be a resource and is rejected.
+func flatten(doc interface{}) ([]map[string]interface{}, error) {
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		return []map[string]interface{}{v}, nil
+	case []interface{}:
+		rl := []map[string]interface{}{}
+		for _, elem := range v {
+			sub, err := flatten(elem)
+			if err != nil {
+				return nil, err
+			}
+			rl = append(rl, sub...)
+		}
+		return rl, nil
+	default:
+		return nil, errors.Errorf(
+			"Expected resource object or array of resources, got %v", doc,
+		)
+	}
+}
@@ -0,0 +1,182 @@
+// main file for facts package
+
+// The facts package gathers facts about the node jcfg is running on, such as
+// its hostname, OS and addresses, for templates to vary content by.
+//
+package facts
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// How long to wait on DNS when looking up the FQDN
+const fqdnTimeout = 2 * time.Second
+
+type Facts struct {
+	Hostname   string      `json:"hostname"` // Short hostname, w/o the domain
+	FQDN       string      `json:"fqdn"`     // Hostname if it can't be resolved
+	Domain     string      `json:"domain"`
+	OS         OS          `json:"os"`
+	Kernel     string      `json:"kernel"` // Kernel release, e.g. 3.10.0-1160.el7.x86_64
+	Arch       string      `json:"arch"`   // GOARCH, e.g. amd64
+	CPUs       int         `json:"cpus"`
+	MemoryMB   int         `json:"memoryMB"`
+	Interfaces []Interface `json:"interfaces"`
+}
+
+// Identity of the distribution, from /etc/os-release
+type OS struct {
+	ID        string   `json:"id"` // e.g. rhel, centos, fedora
+	Name      string   `json:"name"`
+	Version   string   `json:"version"`
+	VersionID string   `json:"versionID"` // e.g. 7.9
+	Like      []string `json:"like"`      // Related distributions, from ID_LIKE
+}
+
+// A network interface that's up, w/ its addresses in CIDR form
+type Interface struct {
+	Name      string   `json:"name"`
+	MAC       string   `json:"mac"`
+	Addresses []string `json:"addresses"`
+}
+
+// Gather facts about this node
+func Gather() (*Facts, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, errors.Errorf("Unable to get hostname: %v", err)
+	}
+	f := Facts{
+		Hostname: hostname,
+		FQDN:     lookupFQDN(hostname),
+		Arch:     runtime.GOARCH,
+		CPUs:     runtime.NumCPU(),
+	}
+	if i := strings.Index(f.FQDN, "."); i >= 0 {
+		f.Hostname = f.FQDN[:i]
+		f.Domain = f.FQDN[i+1:]
+	}
+	if f.OS, err = readOSRelease("/etc/os-release"); err != nil {
+		return nil, err
+	}
+	release, err := ioutil.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return nil, errors.Errorf("Unable to read kernel release: %v", err)
+	}
+	f.Kernel = strings.TrimSpace(string(release))
+	if f.MemoryMB, err = readMemTotal("/proc/meminfo"); err != nil {
+		return nil, err
+	}
+	if f.Interfaces, err = interfaces(); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// Resolve the canonical name of hostname, falling back to hostname itself
+func lookupFQDN(hostname string) string {
+	if strings.Contains(hostname, ".") {
+		return hostname
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), fqdnTimeout)
+	defer cancel()
+	cname, err := net.DefaultResolver.LookupCNAME(ctx, hostname)
+	if err != nil || cname == "" {
+		return hostname
+	}
+	return strings.TrimSuffix(cname, ".")
+}
+
+// Parse the KEY=value lines of an os-release file
+func readOSRelease(fp string) (OS, error) {
+	data, err := ioutil.ReadFile(fp)
+	if os.IsNotExist(err) {
+		return OS{}, nil
+	}
+	if err != nil {
+		return OS{}, errors.Errorf("Unable to read %s: %v", fp, err)
+	}
+	values := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := parts[1]
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, `'"`)
+		}
+		values[parts[0]] = value
+	}
+	return OS{
+		ID:        values["ID"],
+		Name:      values["NAME"],
+		Version:   values["VERSION"],
+		VersionID: values["VERSION_ID"],
+		Like:      strings.Fields(values["ID_LIKE"]),
+	}, nil
+}
+
+// Read the total memory from a meminfo file, in MB
+func readMemTotal(fp string) (int, error) {
+	data, err := ioutil.ReadFile(fp)
+	if err != nil {
+		return 0, errors.Errorf("Unable to read %s: %v", fp, err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "MemTotal:" {
+			continue
+		}
+		kb, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return 0, errors.Errorf("Unable to parse MemTotal %s: %v", fields[1], err)
+		}
+		return kb / 1024, nil
+	}
+	return 0, errors.Errorf("No MemTotal in %s", fp)
+}
+
+// List the interfaces that are up, other than loopback
+func interfaces() ([]Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, errors.Errorf("Unable to list interfaces: %v", err)
+	}
+	var result []Interface
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, errors.Errorf(
+				"Unable to list addresses of %s: %v", iface.Name, err,
+			)
+		}
+		i := Interface{Name: iface.Name, MAC: iface.HardwareAddr.String()}
+		for _, addr := range addrs {
+			i.Addresses = append(i.Addresses, addr.String())
+		}
+		result = append(result, i)
+	}
+	return result, nil
+}
@@ -0,0 +1,247 @@
+// main file for fetch package
+
+// The fetch package downloads content over HTTP(S), pinned by SHA-256. What's
+// downloaded is cached on disk, and revalidated w/ conditional requests so
+// unchanged content isn't downloaded again.
+//
+package fetch
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"example.com/jcfg/pkg/atomicfile"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Where downloads are cached unless told otherwise
+const DefaultCacheDir = "/var/lib/jcfg/cache"
+
+// How long a request may take unless told otherwise
+const DefaultTimeout = time.Minute
+
+// A download to make
+type Request struct {
+	URL      string
+	SHA256   string            // Hex SHA-256 the content must have
+	CABundle string            // PEM file of CAs to trust in place of the system's
+	Headers  map[string]string // Sent w/ the request, not cached
+	Timeout  time.Duration     // Whole request, DefaultTimeout if 0
+}
+
+// What's kept alongside cached content to revalidate it
+type cacheMeta struct {
+	URL          string    `json:"url"`
+	SHA256       string    `json:"sha256"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	Time         time.Time `json:"time"`
+}
+
+// Downloads requests, caching content under CacheDir. Content is cached at
+// <dir>/<SHA-256 of the url>, w/ a .json file next to it to revalidate it.
+type Fetcher struct {
+	CacheDir  string          // Empty to download every time
+	Transport *http.Transport // Cloned for each request, http.DefaultTransport if nil
+}
+
+func New(cacheDir string) *Fetcher {
+	return &Fetcher{CacheDir: cacheDir}
+}
+
+// Fetch the content of req, checking it has the expected SHA-256. Cached
+// content is only used once the server says it's unchanged, or if the server
+// can't be reached. A cached copy w/o the expected SHA-256, after the pin
+// changed or the cache was damaged, is never used or revalidated; the content
+// is downloaded again instead.
+func (f *Fetcher) Fetch(
+	ctx context.Context, req *Request, log *logrus.Logger,
+) ([]byte, error) {
+	cached, meta := f.readCache(req.URL, log)
+	if cached != nil && checkSum(cached, req.SHA256) != nil {
+		log.Debugf("Cached copy of %s is stale, downloading again\n", req.URL)
+		cached, meta = nil, nil
+	}
+	data, status, newMeta, err := f.download(ctx, req, meta)
+	if err != nil {
+		if ctx.Err() != nil || cached == nil {
+			return nil, err
+		}
+		log.Warnf("Using cached copy of %s: %v\n", req.URL, err)
+		return cached, nil
+	}
+	if status == http.StatusNotModified {
+		// Only asked conditionally w/ a cached copy that has the right sum
+		log.Debugf("%s not modified, using cached copy\n", req.URL)
+		return cached, nil
+	}
+	if err := checkSum(data, req.SHA256); err != nil {
+		return nil, errors.Errorf("Downloaded %s: %v", req.URL, err)
+	}
+	log.Debugf("Downloaded %d bytes from %s\n", len(data), req.URL)
+	if err := f.writeCache(req.URL, data, newMeta); err != nil {
+		log.Warnf("Unable to cache %s: %v\n", req.URL, err)
+	}
+	return data, nil
+}
+
+// Make the request, conditional on meta if set. Returns the body and status
+// of a 200, or just the status of a 304.
+func (f *Fetcher) download(
+	ctx context.Context, req *Request, meta *cacheMeta,
+) ([]byte, int, *cacheMeta, error) {
+	client, err := f.client(req)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodGet, req.URL, nil)
+	if err != nil {
+		return nil, 0, nil, errors.Errorf(
+			"Unable to build request for %s: %v", req.URL, err,
+		)
+	}
+	for name, value := range req.Headers {
+		hreq.Header.Set(name, value)
+	}
+	if meta != nil {
+		if meta.ETag != "" {
+			hreq.Header.Set("If-None-Match", meta.ETag)
+		}
+		if meta.LastModified != "" {
+			hreq.Header.Set("If-Modified-Since", meta.LastModified)
+		}
+	}
+	resp, err := client.Do(hreq)
+	if err != nil {
+		return nil, 0, nil, errors.Errorf("Unable to fetch %s: %v", req.URL, err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		if meta != nil {
+			return nil, resp.StatusCode, nil, nil
+		}
+		fallthrough
+	default:
+		return nil, 0, nil, errors.Errorf(
+			"Unable to fetch %s: unexpected status %s", req.URL, resp.Status,
+		)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, nil, errors.Errorf("Unable to read %s: %v", req.URL, err)
+	}
+	return data, resp.StatusCode, &cacheMeta{
+		URL:          req.URL,
+		SHA256:       req.SHA256,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Time:         time.Now().UTC(),
+	}, nil
+}
+
+// Build a client for req, trusting its CA bundle if set
+func (f *Fetcher) client(req *Request) (*http.Client, error) {
+	base := f.Transport
+	if base == nil {
+		base = http.DefaultTransport.(*http.Transport)
+	}
+	transport := base.Clone()
+	if req.CABundle != "" {
+		pem, err := ioutil.ReadFile(req.CABundle)
+		if err != nil {
+			return nil, errors.Errorf(
+				"Unable to read CA bundle %s: %v", req.CABundle, err,
+			)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf(
+				"No certificates found in CA bundle %s", req.CABundle,
+			)
+		}
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+	timeout := req.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
+
+// Check data has the expected hex SHA-256
+func checkSum(data []byte, expected string) error {
+	sum := sha256.Sum256(data)
+	if actual := hex.EncodeToString(sum[:]); actual != expected {
+		return errors.Errorf("SHA-256 is %s, expected %s", actual, expected)
+	}
+	return nil
+}
+
+func (f *Fetcher) cachePath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(f.CacheDir, hex.EncodeToString(sum[:]))
+}
+
+// Read the cached content of url and what's needed to revalidate it. Returns
+// nils if there's no usable cached copy.
+func (f *Fetcher) readCache(
+	url string, log *logrus.Logger,
+) ([]byte, *cacheMeta) {
+	if f.CacheDir == "" {
+		return nil, nil
+	}
+	cp := f.cachePath(url)
+	metaData, err := ioutil.ReadFile(cp + ".json")
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Unable to read cache of %s: %v\n", url, err)
+		}
+		return nil, nil
+	}
+	var meta cacheMeta
+	if err := json.Unmarshal(metaData, &meta); err != nil || meta.URL != url {
+		log.Warnf("Ignoring bad cache of %s\n", url)
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(cp)
+	if err != nil {
+		log.Warnf("Unable to read cache of %s: %v\n", url, err)
+		return nil, nil
+	}
+	return data, &meta
+}
+
+// Cache the content of url. Content is written before its meta, so the meta
+// never describes content that isn't there.
+func (f *Fetcher) writeCache(url string, data []byte, meta *cacheMeta) error {
+	if f.CacheDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(f.CacheDir, 0700); err != nil {
+		return errors.Errorf("Unable to create cache dir: %v", err)
+	}
+	metaData, err := json.Marshal(meta)
+	if err != nil {
+		return errors.Errorf("Unable to marshal cache meta: %v", err)
+	}
+	cp := f.cachePath(url)
+	if err := atomicfile.Write(cp, data, 0600, nil); err != nil {
+		return err
+	}
+	return atomicfile.Write(cp+".json", metaData, 0600, nil)
+}
@@ -0,0 +1,241 @@
+package fetch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	stdlog "log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func sum(data string) string {
+	s := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(s[:])
+}
+
+func testLogger() *logrus.Logger {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	return log
+}
+
+// Serves body w/ an ETag, answering 304 to any conditional request. Records
+// the requests it gets.
+type server struct {
+	lock     sync.Mutex
+	body     string
+	requests []*http.Request
+	full     int // Responses w/ a body
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests = append(s.requests, r)
+	if r.Header.Get("If-None-Match") != "" {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	s.full++
+	w.Header().Set("ETag", `"`+sum(s.body)+`"`)
+	w.Write([]byte(s.body))
+}
+
+func (s *server) setBody(body string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.body = body
+}
+
+func (s *server) downloads() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.full
+}
+
+func (s *server) last() *http.Request {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.requests[len(s.requests)-1]
+}
+
+func fetch(
+	t *testing.T, f *Fetcher, url string, pin string,
+) ([]byte, error) {
+	t.Helper()
+	return f.Fetch(
+		context.Background(), &Request{URL: url, SHA256: pin}, testLogger(),
+	)
+}
+
+func TestFetchRejectsMismatch(t *testing.T) {
+	srv := &server{body: "content\n"}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	f := New(t.TempDir())
+	_, err := fetch(t, f, ts.URL, sum("other\n"))
+	if err == nil || !strings.Contains(err.Error(), "SHA-256 is "+sum("content\n")) {
+		t.Fatalf("expected a SHA-256 mismatch, got %v", err)
+	}
+	if entries, _ := ioutil.ReadDir(f.CacheDir); len(entries) != 0 {
+		t.Errorf("cached content that failed its SHA-256")
+	}
+}
+
+func TestFetchRevalidatesCache(t *testing.T) {
+	srv := &server{body: "content\n"}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	f := New(t.TempDir())
+	for i := 0; i < 2; i++ {
+		data, err := fetch(t, f, ts.URL, sum("content\n"))
+		if err != nil {
+			t.Fatalf("fetch %d: %v", i, err)
+		}
+		if string(data) != "content\n" {
+			t.Errorf("fetch %d got %q", i, data)
+		}
+	}
+	if n := srv.downloads(); n != 1 {
+		t.Errorf("downloaded %d times, expected once then a 304", n)
+	}
+	if inm := srv.last().Header.Get("If-None-Match"); inm == "" {
+		t.Errorf("second request wasn't conditional")
+	}
+}
+
+func TestFetchFallsBackToCache(t *testing.T) {
+	srv := &server{body: "content\n"}
+	ts := httptest.NewServer(srv)
+	f := New(t.TempDir())
+	if _, err := fetch(t, f, ts.URL, sum("content\n")); err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	ts.Close()
+	data, err := fetch(t, f, ts.URL, sum("content\n"))
+	if err != nil || string(data) != "content\n" {
+		t.Errorf("expected the cached copy w/ the server down, got %q, %v", data, err)
+	}
+	// A cached copy w/o the pinned sum is never used
+	if _, err := fetch(t, f, ts.URL, sum("new\n")); err == nil {
+		t.Errorf("used a cached copy w/ the wrong SHA-256")
+	}
+}
+
+func TestFetchStaleCache(t *testing.T) {
+	srv := &server{body: "v1\n"}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	f := New(t.TempDir())
+	if _, err := fetch(t, f, ts.URL, sum("v1\n")); err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+
+	// The pin moves to new content. The server would answer a conditional
+	// request w/ 304, so the request must not be conditional.
+	srv.setBody("v2\n")
+	data, err := fetch(t, f, ts.URL, sum("v2\n"))
+	if err != nil || string(data) != "v2\n" {
+		t.Fatalf("expected v2 after the pin changed, got %q, %v", data, err)
+	}
+	if inm := srv.last().Header.Get("If-None-Match"); inm != "" {
+		t.Errorf("revalidated a cached copy w/ the wrong SHA-256")
+	}
+
+	// Damaged cache content is downloaded again, and the cache repaired
+	cp := f.cachePath(ts.URL)
+	if err := ioutil.WriteFile(cp, []byte("v2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := fetch(t, f, ts.URL, sum("v2\n")); err != nil || string(data) != "v2\n" {
+		t.Fatalf("expected v2 w/ a damaged cache, got %q, %v", data, err)
+	}
+	if cached, _ := ioutil.ReadFile(cp); string(cached) != "v2\n" {
+		t.Errorf("cache not repaired, holds %q", cached)
+	}
+}
+
+func TestFetchSendsHeaders(t *testing.T) {
+	srv := &server{body: "content\n"}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	req := &Request{
+		URL:     ts.URL,
+		SHA256:  sum("content\n"),
+		Headers: map[string]string{"Authorization": "Bearer token"},
+	}
+	if _, err := New("").Fetch(context.Background(), req, testLogger()); err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if auth := srv.last().Header.Get("Authorization"); auth != "Bearer token" {
+		t.Errorf("server got Authorization %q", auth)
+	}
+}
+
+func TestFetchCABundle(t *testing.T) {
+	srv := &server{body: "content\n"}
+	ts := httptest.NewUnstartedServer(srv)
+	// Quiet the handshake error the untrusted fetch causes
+	ts.Config.ErrorLog = stdlog.New(ioutil.Discard, "", 0)
+	ts.StartTLS()
+	defer ts.Close()
+	pin := sum("content\n")
+
+	// Not trusted by default
+	if _, err := fetch(t, New(""), ts.URL, pin); err == nil {
+		t.Errorf("trusted a self-signed server w/o a CA bundle")
+	}
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(
+		&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw},
+	)
+	if err := ioutil.WriteFile(bundle, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	req := &Request{URL: ts.URL, SHA256: pin, CABundle: bundle}
+	if _, err := New("").Fetch(context.Background(), req, testLogger()); err != nil {
+		t.Errorf("fetch w/ CA bundle: %v", err)
+	}
+
+	// Or trusted through the transport the fetcher is given
+	f := &Fetcher{Transport: ts.Client().Transport.(*http.Transport)}
+	if _, err := fetch(t, f, ts.URL, pin); err != nil {
+		t.Errorf("fetch w/ the server's transport: %v", err)
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		},
+	))
+	defer ts.Close()
+	defer close(release)
+	req := &Request{
+		URL: ts.URL, SHA256: sum(""), Timeout: 50 * time.Millisecond,
+	}
+	started := time.Now()
+	_, err := New("").Fetch(context.Background(), req, testLogger())
+	if err == nil {
+		t.Fatalf("fetch didn't time out")
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("fetch took %s to time out", elapsed)
+	}
+}
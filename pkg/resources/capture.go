@@ -0,0 +1,132 @@
+package resources
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Bytes of each output stream kept by default
+const defaultOutputLimit = 1 << 20
+
+// Lines of each output stream kept for failure errors
+const outputTailLines = 10
+
+// Longest line buffered before it's logged anyway, so output w/o newlines
+// can't grow without bound
+const maxLineLength = 64 << 10
+
+// Captures a stream of command output as it's written. Every line is logged
+// as it completes, the first limit bytes are kept for the report, and the
+// last few lines are kept to explain failures.
+type outputCapture struct {
+	name    string // Key of the resource running the command
+	stream  string // stdout or stderr
+	log     *logrus.Logger
+	limit   int
+	kept    bytes.Buffer
+	dropped int
+	line    []byte // Incomplete last line
+	tail    []string
+}
+
+func newOutputCapture(
+	name string, stream string, limit int, log *logrus.Logger,
+) *outputCapture {
+	if limit <= 0 {
+		limit = defaultOutputLimit
+	}
+	return &outputCapture{name: name, stream: stream, limit: limit, log: log}
+}
+
+func (c *outputCapture) Write(p []byte) (int, error) {
+	// Keep what fits under the limit
+	keep := c.limit - c.kept.Len()
+	if keep > len(p) {
+		keep = len(p)
+	}
+	c.kept.Write(p[:keep])
+	c.dropped += len(p) - keep
+
+	// Log each complete line
+	c.line = append(c.line, p...)
+	for {
+		i := bytes.IndexByte(c.line, '\n')
+		if i < 0 {
+			break
+		}
+		c.emit(string(c.line[:i]))
+		c.line = c.line[i+1:]
+	}
+	if len(c.line) > maxLineLength {
+		c.emit(string(c.line))
+		c.line = nil
+	}
+	return len(p), nil
+}
+
+// Log a line and add it to the tail
+func (c *outputCapture) emit(line string) {
+	line = strings.TrimSuffix(line, "\r")
+	c.log.Infof("%s %s: %s\n", c.name, c.stream, line)
+	c.tail = append(c.tail, line)
+	if len(c.tail) > outputTailLines {
+		c.tail = c.tail[1:]
+	}
+}
+
+// Log the last line if it didn't end in a newline. Call once the command has
+// exited.
+func (c *outputCapture) Flush() {
+	if len(c.line) != 0 {
+		c.emit(string(c.line))
+		c.line = nil
+	}
+}
+
+// Output kept, w/ a note of how much was dropped if over the limit
+func (c *outputCapture) String() string {
+	if c.dropped == 0 {
+		return c.kept.String()
+	}
+	return fmt.Sprintf(
+		"%s\n[%d bytes over the %d byte output limit dropped]\n",
+		c.kept.String(), c.dropped, c.limit,
+	)
+}
+
+// Was any output dropped
+func (c *outputCapture) Truncated() bool {
+	return c.dropped != 0
+}
+
+// Output kept, without any note
+func (c *outputCapture) Kept() string {
+	return c.kept.String()
+}
+
+// Last lines of output, indented for an error message
+func (c *outputCapture) Tail() string {
+	if len(c.tail) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(
+		"%s tail:\n  %s", c.stream, strings.Join(c.tail, "\n  "),
+	)
+}
+
+// Adds the tail of a command's output to the error it failed with
+type tailError struct {
+	err  error
+	tail string
+}
+
+func (e *tailError) Error() string {
+	return e.err.Error() + "\n" + e.tail
+}
+
+func (e *tailError) Unwrap() error {
+	return e.err
+}
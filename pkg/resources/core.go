@@ -1,20 +1,31 @@
 package resources
 
 import (
+	"context"
+	"encoding/hex"
 	"io/ioutil"
+	"net/url"
 	"os/user"
 	"strconv"
 	"strings"
+	"time"
 
 	"example.com/jcfg/pkg/api"
+	"example.com/jcfg/pkg/fetch"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
-// Retrieve the content source for a resource
-func getContent(con *api.ContentSpec, log *logrus.Logger) (
-	[]byte, error,
-) {
+// Fetcher HTTP sources are downloaded w/. Downloads aren't cached unless its
+// cache dir is set.
+var HTTPFetcher = fetch.New("")
+
+// Retrieve the content source for resource r. Outputs are read from the value
+// store attached to ctx, and templates rendered w/ its template env.
+func getContent(
+	ctx context.Context, r api.Resource, con *api.ContentSpec,
+	log *logrus.Logger,
+) ([]byte, error) {
 	switch strings.ToLower(con.Type) {
 	case "string":
 		return []byte(con.String), nil
@@ -26,8 +37,26 @@ func getContent(con *api.ContentSpec, log *logrus.Logger) (
 			)
 		}
 		return sourceData, nil
+	case "output":
+		store := api.ValueStoreFrom(ctx)
+		if store == nil {
+			return nil, errors.Errorf("No value store to read output %s from", con.Output)
+		}
+		value, ok := store.Get(con.Output)
+		if !ok {
+			return nil, errors.Errorf(
+				"Output %s not published, did %s run?", con.Output, con.Output.Resource,
+			)
+		}
+		return []byte(value), nil
+	case "template":
+		return renderTemplate(ctx, r, &con.Template)
 	case "httpsource":
-		return nil, errors.Errorf("HTTPSource currently not implemented\n")
+		req, err := httpRequest(&con.HTTPSource)
+		if err != nil {
+			return nil, err
+		}
+		return HTTPFetcher.Fetch(ctx, req, log)
 	case "secret":
 		return nil, errors.Errorf("Secret currently not implemented\n")
 	default:
@@ -35,6 +64,57 @@ func getContent(con *api.ContentSpec, log *logrus.Logger) (
 	}
 }
 
+// Check a content source is usable when the catalog loads. Only sources w/
+// something to check are checked.
+func validateContent(name string, con *api.ContentSpec) error {
+	switch strings.ToLower(con.Type) {
+	case "template":
+		return validateTemplate(name, &con.Template)
+	case "httpsource":
+		_, err := httpRequest(&con.HTTPSource)
+		return err
+	}
+	return nil
+}
+
+// Build the request for an HTTP source, checking it's usable
+func httpRequest(hs *api.HTTPSourceSpec) (*fetch.Request, error) {
+	u, err := url.Parse(hs.URL)
+	if err != nil {
+		return nil, errors.Errorf("unable to parse url %s: %v", hs.URL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Errorf("url %s must be http or https", hs.URL)
+	}
+	if _, err := hex.DecodeString(hs.SHA256); err != nil || len(hs.SHA256) != 64 {
+		return nil, errors.Errorf(
+			"sha256 of %s must be set to a hex SHA-256, got %q", hs.URL, hs.SHA256,
+		)
+	}
+	req := fetch.Request{
+		URL:      hs.URL,
+		SHA256:   strings.ToLower(hs.SHA256),
+		CABundle: hs.CABundle,
+		Headers:  hs.Headers,
+	}
+	if hs.Timeout != "" {
+		if req.Timeout, err = time.ParseDuration(hs.Timeout); err != nil {
+			return nil, errors.Errorf(
+				"unable to parse timeout %s: %v", hs.Timeout, err,
+			)
+		}
+	}
+	return &req, nil
+}
+
+// List the outputs a content source reads, if any
+func contentOutputs(con *api.ContentSpec) []api.OutputRef {
+	if strings.ToLower(con.Type) != "output" {
+		return nil
+	}
+	return []api.OutputRef{con.Output}
+}
+
 // Return UID and GID from UserIdentifierSpec. Just drops int values if set,
 // otherwise does lookups for user/group name
 func lookupUidGid(
@@ -3,8 +3,9 @@ package resources
 import (
 	"bytes"
 	"context"
-	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
@@ -21,6 +22,101 @@ type Exec struct {
 	Spec     api.ExecSpec
 }
 
+func init() {
+	Register(Kind{
+		Api:      "v1",
+		Kind:     "Exec",
+		New:      func() api.Resource { return &Exec{} },
+		Validate: validateExec,
+	})
+}
+
+// Check the spec of a loaded exec resource is usable
+func validateExec(r api.Resource) error {
+	e, ok := r.(*Exec)
+	if !ok {
+		return errors.Errorf("Resource %s is not an Exec", r.GetName())
+	}
+	es := &e.Spec
+	if es.Path == "" {
+		return errors.Errorf("%s: path must be set", e.GetName())
+	}
+	if es.Timeout != "" {
+		if _, err := time.ParseDuration(es.Timeout); err != nil {
+			return errors.Errorf(
+				"%s: unable to parse timeout %s: %v", e.GetName(), es.Timeout, err,
+			)
+		}
+	}
+	if es.Creates != "" && !filepath.IsAbs(es.Creates) {
+		return errors.Errorf(
+			"%s: creates must be an absolute path, got %s", e.GetName(), es.Creates,
+		)
+	}
+	if err := validateExpectation("stdout", &es.Stdout); err != nil {
+		return errors.Errorf("%s: %v", e.GetName(), err)
+	}
+	if err := validateExpectation("stderr", &es.Stderr); err != nil {
+		return errors.Errorf("%s: %v", e.GetName(), err)
+	}
+	names := make(map[string]bool)
+	for i := range es.Outputs {
+		o := &es.Outputs[i]
+		if names[o.Name] {
+			return errors.Errorf("%s: output %q set twice", e.GetName(), o.Name)
+		}
+		names[o.Name] = true
+		if err := validateExecOutput(o); err != nil {
+			return errors.Errorf("%s: %v", e.GetName(), err)
+		}
+	}
+	// A run skipped by a guard publishes nothing, failing every reader on
+	// each apply after the first
+	if guards := execGuards(es); len(es.Outputs) != 0 && len(guards) != 0 {
+		return errors.Errorf(
+			"%s: outputs can't be published by an exec guarded by %s",
+			e.GetName(), strings.Join(guards, ", "),
+		)
+	}
+	if es.OutputLimit < 0 {
+		return errors.Errorf(
+			"%s: outputLimit %d is negative", e.GetName(), es.OutputLimit,
+		)
+	}
+	if len(es.OnlyIf) != 0 && es.OnlyIf[0] == "" {
+		return errors.Errorf("%s: onlyIf must start w/ a path", e.GetName())
+	}
+	if len(es.Unless) != 0 && es.Unless[0] == "" {
+		return errors.Errorf("%s: unless must start w/ a path", e.GetName())
+	}
+	for i := range es.Env {
+		if err := validateContent(e.GetName(), &es.Env[i].Value); err != nil {
+			return errors.Errorf(
+				"%s: env var %s: %v", e.GetName(), es.Env[i].Name, err,
+			)
+		}
+	}
+	return nil
+}
+
+// List the guards set on an exec that may skip its command
+func execGuards(es *api.ExecSpec) []string {
+	var guards []string
+	if es.Creates != "" {
+		guards = append(guards, "creates")
+	}
+	if len(es.OnlyIf) != 0 {
+		guards = append(guards, "onlyIf")
+	}
+	if len(es.Unless) != 0 {
+		guards = append(guards, "unless")
+	}
+	if es.RefreshOnly {
+		guards = append(guards, "refreshOnly")
+	}
+	return guards
+}
+
 func (e *Exec) GetApi() string {
 	return e.Api
 }
@@ -37,30 +133,52 @@ func (e *Exec) Fail(log *logrus.Logger, err error) error {
 	if e.Spec.FailOk == true {
 		log.Infof("%s: Caught err, continuing\n", e.GetName())
 		log.Infof("%v\n", err)
-		e.Done()
-		return nil
+		return e.Done()
 	} else {
-		e.Metadata.State.Failed = true
+		if tErr := e.Transition(api.PhaseFailed, err.Error()); tErr != nil {
+			return errors.Errorf("%v: %v", err, tErr)
+		}
 		return err
 	}
 }
-func (e *Exec) Done() {
-	e.Metadata.State.Completed = true
+func (e *Exec) Done() error {
+	return e.Transition(api.PhaseCompleted, "")
+}
+func (e *Exec) Transition(to api.Phase, reason string) error {
+	return e.Metadata.State.Transition(to, reason)
 }
 
 func (e *Exec) Init() {
-	e.Metadata.State.Completed = false
-	e.Metadata.State.Failed = false
+	e.Metadata.State = api.StateDef{Phase: api.PhasePending}
+}
+
+// Names of the outputs published when the command runs
+func (e *Exec) Produces() []string {
+	var names []string
+	for _, o := range e.Spec.Outputs {
+		names = append(names, o.Name)
+	}
+	return names
+}
+
+// Outputs read by env vars
+func (e *Exec) Consumes() []api.OutputRef {
+	var refs []api.OutputRef
+	for i := range e.Spec.Env {
+		refs = append(refs, contentOutputs(&e.Spec.Env[i].Value)...)
+	}
+	return refs
 }
 
 func loadEnv(
-	cmd *exec.Cmd, envSpec []api.EnvSpec, log *logrus.Logger,
+	ctx context.Context, r api.Resource, cmd *exec.Cmd, envSpec []api.EnvSpec,
+	log *logrus.Logger,
 ) error {
 
 	// Build output array with length of the input env spec array
 	output := make([]string, len(envSpec))
 	for i, env := range envSpec {
-		content, err := getContent(&env.Value, log)
+		content, err := getContent(ctx, r, &env.Value, log)
 		if err != nil {
 			return errors.Errorf(
 				"Unable to fetch content for env var %s: %v", env.Name, err,
@@ -75,18 +193,10 @@ func loadEnv(
 	return nil
 }
 
-func outputToString(r io.Reader, log *logrus.Logger) (string, error) {
-	buf := new(strings.Builder)
-	if _, err := io.Copy(buf, r); err != nil {
-		return "", errors.Errorf("Unable to copy output to string: %v", err)
-	}
-
-	return buf.String(), nil
-}
-
+// Check the command ran, exited w/ an expected code and its output matches
+// every expectation
 func checkRunCommand(
-	es *api.ExecSpec, cmd *exec.Cmd, iErr error,
-	stderr *bytes.Buffer, stdout *bytes.Buffer, name string, log *logrus.Logger,
+	es *api.ExecSpec, iErr error, stdout *outputCapture, stderr *outputCapture,
 ) error {
 
 	// If command failed w/ non-exit error type
@@ -95,63 +205,87 @@ func checkRunCommand(
 		return errors.Errorf("Command failed: %v", iErr)
 	}
 
-	// If command stder didn't match expected
-	// jk we don't care
-	sStderr, err := outputToString(stderr, log)
-	if err != nil {
-		return errors.Errorf("Reading stderr failed: %v", err)
+	// If exit code doesn't match expected
+	exitCode := 0
+	if exitErr != nil {
+		exitCode = exitErr.ExitCode()
 	}
-	if len(sStderr) != 0 {
-		log.Infof("%s Stderr:\n%s\n", name, sStderr)
+	expected := es.ExitCodes
+	if len(expected) == 0 {
+		expected = []int{es.ExitCode}
 	}
-
-	// If command stdout didn't match expected
-	// jk we don't care
-	sStdout, err := outputToString(stdout, log)
-	if err != nil {
-		return errors.Errorf("Reading stdout failed: %v", err)
+	codeOk := false
+	for _, code := range expected {
+		if code == exitCode {
+			codeOk = true
+			break
+		}
 	}
-	if len(sStdout) != 0 {
-		log.Infof("%s Stdout:\n%s\n", name, sStdout)
+	if !codeOk {
+		return &api.ExitCodeError{Code: exitCode, Expected: expected}
 	}
 
-	// If exit code doesn't match expected
-	exitCode := 0
-	if exitErr != nil {
-		exitCode = exitErr.ExitCode()
+	// If command output didn't match expected
+	if err := matchCaptured(stdout, &es.Stdout); err != nil {
+		return errors.Errorf("Stdout didn't match: %v", err)
 	}
-	if exitCode != es.ExitCode {
-		return errors.Errorf("Exit code %d != expected %d", exitCode, es.ExitCode)
+	if err := matchCaptured(stderr, &es.Stderr); err != nil {
+		return errors.Errorf("Stderr didn't match: %v", err)
 	}
 
 	return nil
 }
 
-func (e *Exec) Apply(ictx context.Context, log *logrus.Logger) error {
-	es := &e.Spec
-
-	//	key := strings.Title(e.Kind) + "::" + e.Metadata.Name
+func (e *Exec) Apply(ictx context.Context, log *logrus.Logger) (
+	api.Result, error,
+) {
+	drift, err := e.Check(ictx, log)
+	if err != nil {
+		return api.Result{}, err
+	}
+	if drift.InSync() {
+		return api.Result{}, nil
+	}
+	result, err := e.run(ictx, log)
+	if err != nil {
+		return result, err
+	}
+	result.Changed = true
+	result.Changes = drift.Changes
+	return result, nil
+}
 
-	// Set context w/ Timeout if it exists
-	var ctx context.Context
-	if es.Timeout != "" {
-		duration, err := time.ParseDuration(es.Timeout)
-		if err != nil {
-			return errors.Errorf("Unable to parse time %s: %v", es.Timeout, err)
-		}
-		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ictx, duration)
-		defer cancel()
-	} else {
-		ctx = ictx
+// Set context w/ the spec's timeout if it has one
+func (e *Exec) withTimeout(ictx context.Context) (
+	context.Context, context.CancelFunc, error,
+) {
+	es := &e.Spec
+	if es.Timeout == "" {
+		ctx, cancel := context.WithCancel(ictx)
+		return ctx, cancel, nil
 	}
+	duration, err := time.ParseDuration(es.Timeout)
+	if err != nil {
+		return nil, nil, errors.Errorf(
+			"Unable to parse time %s: %v", es.Timeout, err,
+		)
+	}
+	ctx, cancel := context.WithTimeout(ictx, duration)
+	return ctx, cancel, nil
+}
+
+// Build a command for argv w/ the env, cwd and user/group of the spec
+func (e *Exec) command(
+	ctx context.Context, argv []string, log *logrus.Logger,
+) (*exec.Cmd, error) {
+	es := &e.Spec
 
 	// Build command w/ context, path, args
-	cmd := exec.CommandContext(ctx, es.Path, es.Args...)
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
 
 	// Set env
-	if err := loadEnv(cmd, es.Env, log); err != nil {
-		return errors.Errorf("Unable to load env vars: %v", err)
+	if err := loadEnv(ctx, e, cmd, es.Env, log); err != nil {
+		return nil, errors.Errorf("Unable to load env vars: %v", err)
 	}
 	// Set cwd
 	cmd.Dir = es.Dir
@@ -159,23 +293,191 @@ func (e *Exec) Apply(ictx context.Context, log *logrus.Logger) error {
 	// Set user/group
 	uid, gid, err := lookupUidGid(&es.UserID, log)
 	if err != nil {
-		return errors.Errorf("Unable to look up uid/gid: %v", err)
+		return nil, errors.Errorf("Unable to look up uid/gid: %v", err)
 	}
 	cmd.SysProcAttr = &syscall.SysProcAttr{}
 	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uid, Gid: gid}
+	return cmd, nil
+}
+
+// Run the command. The returned result carries the command output even if
+// the command failed.
+func (e *Exec) run(ictx context.Context, log *logrus.Logger) (
+	api.Result, error,
+) {
+	es := &e.Spec
+
+	ctx, cancel, err := e.withTimeout(ictx)
+	if err != nil {
+		return api.Result{}, err
+	}
+	defer cancel()
+	cmd, err := e.command(ctx, append([]string{es.Path}, es.Args...), log)
+	if err != nil {
+		return api.Result{}, err
+	}
 
-	// Set up output pipes
-	var stderr, stdout bytes.Buffer
-	cmd.Stderr = &stderr
-	cmd.Stdout = &stdout
+	// Set up output pipes, logging lines as they're written
+	stdout := newOutputCapture(e.GetName(), "stdout", es.OutputLimit, log)
+	stderr := newOutputCapture(e.GetName(), "stderr", es.OutputLimit, log)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 
 	// Run
 	err = cmd.Run()
-	err = checkRunCommand(es, cmd, err, &stderr, &stdout, e.GetName(), log)
+	stdout.Flush()
+	stderr.Flush()
+	// Keep the output for the apply report
+	result := api.Result{Stdout: stdout.String(), Stderr: stderr.String()}
+	err = checkRunCommand(es, err, stdout, stderr)
 	if err != nil {
-		return errors.Errorf("CheckRunCommand failed: %v", err)
+		var tails []string
+		for _, c := range []*outputCapture{stdout, stderr} {
+			if tail := c.Tail(); tail != "" {
+				tails = append(tails, tail)
+			}
+		}
+		if len(tails) != 0 {
+			err = &tailError{err: err, tail: strings.Join(tails, "\n")}
+		}
+		// Wrapped rather than formatted so the exit code stays reachable
+		return result, errors.Wrap(err, "CheckRunCommand failed")
+	}
+
+	// Publish outputs for later resources
+	if len(es.Outputs) != 0 {
+		store := api.ValueStoreFrom(ictx)
+		if store == nil {
+			return result, errors.Errorf("No value store to publish outputs to")
+		}
+		if stdout.Truncated() || stderr.Truncated() {
+			return result, errors.Errorf(
+				"Unable to extract outputs, output over the limit was dropped",
+			)
+		}
+		for i := range es.Outputs {
+			o := &es.Outputs[i]
+			value, err := extractOutput(o, stdout.Kept(), stderr.Kept())
+			if err != nil {
+				return result, errors.Errorf("Unable to extract output %s: %v", o.Name, err)
+			}
+			store.Set(api.OutputRef{Resource: e.GetName(), Name: o.Name}, value)
+			log.Debugf("%s: published output %s\n", e.GetName(), o.Name)
+		}
 	}
 
 	// Check command
-	return nil
+	return result, nil
+}
+
+// Run a guard command, returning whether it exited zero. Guards run like the
+// command itself, but only their exit code matters.
+func (e *Exec) guard(
+	ictx context.Context, argv []string, log *logrus.Logger,
+) (bool, error) {
+	ctx, cancel, err := e.withTimeout(ictx)
+	if err != nil {
+		return false, err
+	}
+	defer cancel()
+	cmd, err := e.command(ctx, argv, log)
+	if err != nil {
+		return false, err
+	}
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err = cmd.Run()
+	if output.Len() != 0 {
+		log.Debugf("%s Guard output:\n%s\n", e.GetName(), output.String())
+	}
+	if _, isExitErr := err.(*exec.ExitError); isExitErr && ctx.Err() == nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Errorf("Command failed: %v", err)
+	}
+	return true, nil
+}
+
+// Evaluate the creates, onlyIf and unless guards of the spec. Returns why
+// nothing needs doing, or "" if the command should run.
+func (e *Exec) guarded(ctx context.Context, log *logrus.Logger) (
+	string, error,
+) {
+	es := &e.Spec
+	if es.Creates != "" {
+		_, err := os.Stat(es.Creates)
+		if err == nil {
+			return es.Creates + " exists", nil
+		}
+		if !os.IsNotExist(err) {
+			return "", errors.Errorf("Unable to stat %s: %v", es.Creates, err)
+		}
+	}
+	if len(es.OnlyIf) != 0 {
+		ok, err := e.guard(ctx, es.OnlyIf, log)
+		if err != nil {
+			return "", errors.Errorf("Unable to run onlyIf guard: %v", err)
+		}
+		if !ok {
+			return "onlyIf " + strings.Join(es.OnlyIf, " ") + " exited non-zero", nil
+		}
+	}
+	if len(es.Unless) != 0 {
+		ok, err := e.guard(ctx, es.Unless, log)
+		if err != nil {
+			return "", errors.Errorf("Unable to run unless guard: %v", err)
+		}
+		if ok {
+			return "unless " + strings.Join(es.Unless, " ") + " exited zero", nil
+		}
+	}
+	return "", nil
+}
+
+// Execs have no state to compare against, so they drift unless a guard says
+// nothing needs doing. Refresh only execs only ever run on refresh.
+func (e *Exec) Check(ctx context.Context, log *logrus.Logger) (
+	api.Drift, error,
+) {
+	es := &e.Spec
+	if es.RefreshOnly {
+		return api.Drift{}, nil
+	}
+	reason, err := e.guarded(ctx, log)
+	if err != nil {
+		return api.Drift{}, err
+	}
+	if reason != "" {
+		log.Infof("%s: nothing to do, %s\n", e.GetName(), reason)
+		return api.Drift{}, nil
+	}
+	return api.Drift{Changes: []api.Change{{
+		Attribute: "exec",
+		Message: "will run " + strings.Join(
+			append([]string{es.Path}, es.Args...), " ",
+		),
+	}}}, nil
+}
+
+// Run the command again after a resource notifying us changed, unless a
+// guard says nothing needs doing
+func (e *Exec) Refresh(ctx context.Context, log *logrus.Logger) (
+	api.Result, error,
+) {
+	reason, err := e.guarded(ctx, log)
+	if err != nil {
+		return api.Result{}, err
+	}
+	if reason != "" {
+		log.Infof("%s: not refreshing, %s\n", e.GetName(), reason)
+		return api.Result{}, nil
+	}
+	result, err := e.run(ctx, log)
+	if err != nil {
+		return result, err
+	}
+	result.Changed = true
+	return result, nil
 }
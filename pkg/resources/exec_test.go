@@ -0,0 +1,54 @@
+package resources
+
+import (
+	"strings"
+	"testing"
+
+	"example.com/jcfg/pkg/api"
+)
+
+func TestValidateExecOutputsNeedUnguarded(t *testing.T) {
+	tests := []struct {
+		name  string
+		guard func(es *api.ExecSpec)
+		err   string
+	}{
+		{name: "unguarded", guard: func(es *api.ExecSpec) {}},
+		{
+			name:  "creates",
+			guard: func(es *api.ExecSpec) { es.Creates = "/tmp/marker" },
+			err:   "guarded by creates",
+		},
+		{
+			name:  "onlyIf",
+			guard: func(es *api.ExecSpec) { es.OnlyIf = []string{"/bin/true"} },
+			err:   "guarded by onlyIf",
+		},
+		{
+			name:  "unless",
+			guard: func(es *api.ExecSpec) { es.Unless = []string{"/bin/false"} },
+			err:   "guarded by unless",
+		},
+		{
+			name:  "refreshOnly",
+			guard: func(es *api.ExecSpec) { es.RefreshOnly = true },
+			err:   "guarded by refreshOnly",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &Exec{Api: "v1", Kind: "Exec"}
+			e.Metadata.Name = "gen"
+			e.Spec.Path = "/bin/echo"
+			e.Spec.Outputs = []api.ExecOutput{{Name: "v"}}
+			tt.guard(&e.Spec)
+			err := validateExec(e)
+			if tt.err == "" && err != nil {
+				t.Errorf("validateExec: %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -3,17 +3,27 @@ package resources
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
+	"unicode/utf8"
 
 	"example.com/jcfg/pkg/api"
+	"example.com/jcfg/pkg/atomicfile"
+	"example.com/jcfg/pkg/bucket"
 	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
 	"github.com/sirupsen/logrus"
 )
 
+// Bucket content is backed up to before files are changed or removed. No
+// backups are made if nil.
+var BackupBucket *bucket.Bucket
+
 type File struct {
 	Api      string
 	Kind     string
@@ -21,6 +31,45 @@ type File struct {
 	Spec     api.FileSpec
 }
 
+func init() {
+	Register(Kind{
+		Api:      "v1",
+		Kind:     "File",
+		New:      func() api.Resource { return &File{} },
+		Validate: validateFile,
+	})
+}
+
+// Check the spec of a loaded file resource is usable
+func validateFile(r api.Resource) error {
+	f, ok := r.(*File)
+	if !ok {
+		return errors.Errorf("Resource %s is not a File", r.GetName())
+	}
+	fs := &f.Spec
+	if fs.Path == "" {
+		return errors.Errorf("%s: path must be set", f.GetName())
+	}
+	switch fs.Ensure {
+	case "absent", "directory", "present":
+	case "link":
+		if fs.Target == "" {
+			return errors.Errorf("%s: target must be set to ensure link", f.GetName())
+		}
+	default:
+		return errors.Errorf("%s: cannot ensure %s", f.GetName(), fs.Ensure)
+	}
+	if _, err := desiredMode(fs); err != nil {
+		return errors.Errorf("%s: %v", f.GetName(), err)
+	}
+	if fs.Ensure == "present" {
+		if err := validateContent(f.GetName(), &fs.Content); err != nil {
+			return errors.Errorf("%s: %v", f.GetName(), err)
+		}
+	}
+	return nil
+}
+
 func (f *File) GetApi() string {
 	return f.Api
 }
@@ -34,16 +83,25 @@ func (f *File) GetMetadata() api.MetadataDef {
 	return f.Metadata
 }
 func (f *File) Fail(log *logrus.Logger, err error) error {
-	f.Metadata.State.Failed = true
+	if tErr := f.Transition(api.PhaseFailed, err.Error()); tErr != nil {
+		return errors.Errorf("%v: %v", err, tErr)
+	}
 	return err
 }
-func (f *File) Done() {
-	f.Metadata.State.Completed = true
+func (f *File) Done() error {
+	return f.Transition(api.PhaseCompleted, "")
+}
+func (f *File) Transition(to api.Phase, reason string) error {
+	return f.Metadata.State.Transition(to, reason)
 }
 
 func (f *File) Init() {
-	f.Metadata.State.Completed = false
-	f.Metadata.State.Failed = false
+	f.Metadata.State = api.StateDef{Phase: api.PhasePending}
+}
+
+// Files read an output if their content comes from one
+func (f *File) Consumes() []api.OutputRef {
+	return contentOutputs(&f.Spec.Content)
 }
 
 // Get current permissions of file. Pass in filepath via string, outputs unix
@@ -59,9 +117,8 @@ func getFileMode(fp string, log *logrus.Logger) (os.FileMode, error) {
 	return fi.Mode(), nil
 }
 
-// Ensure mode/permissions of file is set to expected
-func ensureMode(f *api.FileSpec, log *logrus.Logger) error {
-	// Check
+// Parse the expected mode of the file, defaulting to 0600 if unset
+func desiredMode(f *api.FileSpec) (os.FileMode, error) {
 	expectedMode := "0600"
 	// Should do stricter type checking here for valid modes, but w/e
 	if f.Mode != "" {
@@ -70,12 +127,21 @@ func ensureMode(f *api.FileSpec, log *logrus.Logger) error {
 	// Octal 32 bit number
 	intMode, err := strconv.ParseInt(expectedMode, 8, 32)
 	if err != nil {
-		return errors.Errorf(
+		return 0, errors.Errorf(
 			"Unable to convert string mode of %s to octal int32 %s: %v", f.Path,
 			expectedMode, err,
 		)
 	}
-	setMode := os.FileMode(intMode)
+	return os.FileMode(intMode), nil
+}
+
+// Ensure mode/permissions of file is set to expected
+func ensureMode(f *api.FileSpec, log *logrus.Logger) error {
+	// Check
+	setMode, err := desiredMode(f)
+	if err != nil {
+		return err
+	}
 
 	fm, err := getFileMode(f.Path, log)
 	if err != nil {
@@ -92,7 +158,7 @@ func ensureMode(f *api.FileSpec, log *logrus.Logger) error {
 	log.Debugf("Setting mode of %s to %v\n", f.Path, setMode)
 	if err := os.Chmod(f.Path, setMode); err != nil {
 		return errors.Errorf(
-			"Unable to set mode of %s to %s: %v", f.Path, expectedMode, err,
+			"Unable to set mode of %s to %v: %v", f.Path, setMode, err,
 		)
 	}
 
@@ -148,24 +214,63 @@ func ensureFilePresent(f *api.FileSpec, log *logrus.Logger) error {
 		)
 
 	}
-	// If isNotExist error, create file
-	if _, err = os.Create(f.Path); err != nil {
+	// If isNotExist error, create an empty file. Only readable by its owner
+	// until ensureMode sets the mode wanted.
+	file, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
 		return errors.Errorf(
 			"Unable to create %s to ensure present: %v", f.Path, err,
 		)
 	}
+	if err := file.Close(); err != nil {
+		return errors.Errorf("Unable to close %s: %v", f.Path, err)
+	}
 	return nil
 }
 
+// Save the current content of fp to the backup bucket, if there is one
+func backupFile(fp string, log *logrus.Logger) ([]api.Backup, error) {
+	if BackupBucket == nil {
+		return nil, nil
+	}
+	entry, err := BackupBucket.Backup(fp)
+	if err != nil {
+		return nil, errors.Errorf("Unable to back up %s: %v", fp, err)
+	}
+	log.Infof("Backed up %s to bucket as %s\n", fp, entry.Sum)
+	return []api.Backup{{Path: fp, Sum: entry.Sum}}, nil
+}
+
 // Ensure file is absent. Run os.RemoveAll no matter current state (returns nil
-// if path doesn't exist, so we don't need to check ourselves).
-func ensureAbsent(f *api.FileSpec, log *logrus.Logger) error {
+// if path doesn't exist, so we don't need to check ourselves). Every regular
+// file removed is backed up first.
+func ensureAbsent(f *api.FileSpec, log *logrus.Logger) ([]api.Backup, error) {
+	var backups []api.Backup
+	err := filepath.Walk(f.Path, func(fp string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		b, err := backupFile(fp, log)
+		backups = append(backups, b...)
+		return err
+	})
+	if err != nil {
+		return backups, errors.Errorf(
+			"Unable to back up %s to ensure absent: %v", f.Path, err,
+		)
+	}
 	if err := os.RemoveAll(f.Path); err != nil {
-		return errors.Errorf(
+		return backups, errors.Errorf(
 			"Unable to remove %s to ensure absent: %v", f.Path, err,
 		)
 	}
-	return nil
+	return backups, nil
 }
 
 // Get current ownership of file. Pass in filepath via string, outputs uid,
@@ -247,46 +352,71 @@ func checkFileContent(filepath string, expectedData []byte) (bool, error) {
 	return bytes.Compare(actualData, expectedData) == 0, nil
 }
 
-func ensureFileContent(f *api.FileSpec, log *logrus.Logger) error {
-	// Get file content
-	log.Debugf("Loading expected content of %s\n", f.Path)
-	expectedContent, err := getContent(&f.Content, log)
-	log.Debugf("expected content of %s is %s\n", f.Path, string(expectedContent))
-	if err != nil {
-		return errors.Errorf(
-			"Unable to load expected file content for %s: %v", f.Path, err,
-		)
+func ensureFileContent(
+	f *api.FileSpec, expectedContent []byte, log *logrus.Logger,
+) ([]api.Backup, error) {
+	if !f.NoDiff {
+		log.Debugf("expected content of %s is %s\n", f.Path, string(expectedContent))
 	}
 
-	// Check current content
-	log.Debugf("Checking current content of %s\n", f.Path)
-	fileDiff, err := checkFileContent(f.Path, expectedContent)
-	if err != nil {
-		return errors.Errorf("Unable to check file content %s: %v", f.Path, err)
+	// Check current content, if there's a file yet
+	var backups []api.Backup
+	fi, err := os.Lstat(f.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Errorf("Unable to lstat %s: %v", f.Path, err)
 	}
-	if fileDiff == true {
-		log.Debugf("File content matches expected\n")
-		return nil
+	if err == nil {
+		// Only regular files can be backed up, and replaced w/ content
+		if !fi.Mode().IsRegular() {
+			return nil, errors.Errorf(
+				"Unable to set content of %s, not a regular file", f.Path,
+			)
+		}
+		log.Debugf("Checking current content of %s\n", f.Path)
+		fileDiff, err := checkFileContent(f.Path, expectedContent)
+		if err != nil {
+			return nil, errors.Errorf(
+				"Unable to check file content %s: %v", f.Path, err,
+			)
+		}
+		if fileDiff == true {
+			log.Debugf("File content matches expected\n")
+			return nil, nil
+		}
+		// Back up what's there, unless the file is empty
+		if fi.Size() != 0 {
+			if backups, err = backupFile(f.Path, log); err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	// Set content (we're guaranteed the file exists by this point, and perms on
-	// existing files are ignored)
+	// Set content by replacing the file, so it's never seen half written
+	mode, err := desiredMode(f)
+	if err != nil {
+		return backups, err
+	}
+	uid, gid, err := lookupUidGid(&f.UserID, log)
+	if err != nil {
+		return backups, errors.Errorf("Unable to look up uid/gid: %v", err)
+	}
 	log.Debugf("Writing content to %s\n", f.Path)
-	if err = ioutil.WriteFile(f.Path, expectedContent, 000); err != nil {
-		return errors.Errorf("Unable to set file content %s: %v", f.Path, err)
+	owner := &atomicfile.Owner{Uid: uid, Gid: gid}
+	if err = atomicfile.Write(f.Path, expectedContent, mode, owner); err != nil {
+		return backups, errors.Errorf("Unable to set file content %s: %v", f.Path, err)
 	}
 
 	// Verify file content was set correctly
-	fileDiff, err = checkFileContent(f.Path, expectedContent)
+	fileDiff, err := checkFileContent(f.Path, expectedContent)
 	if err != nil {
-		return errors.Errorf("Unable to check file content %s: %v", f.Path, err)
+		return backups, errors.Errorf("Unable to check file content %s: %v", f.Path, err)
 	}
 	if fileDiff != true {
-		return errors.Errorf(
+		return backups, errors.Errorf(
 			"Unable to persistently set content of %s: %v", f.Path, nil,
 		)
 	}
-	return nil
+	return backups, nil
 }
 
 // Readlink filePath, return if matches target
@@ -330,9 +460,48 @@ func ensureLink(f *api.FileSpec, log *logrus.Logger) error {
 	return nil
 }
 
-func (f *File) Apply(ctx context.Context, log *logrus.Logger) error {
+// Check the file, and enforce the spec if it has drifted
+func (f *File) Apply(ctx context.Context, log *logrus.Logger) (
+	api.Result, error,
+) {
+	// Content is resolved once, so what's checked, diffed and written agree
+	desired, err := f.desiredContent(ctx, log)
+	if err != nil {
+		return api.Result{}, errors.Errorf("Unable to check %s: %v", f.Spec.Path, err)
+	}
+	drift, err := f.check(ctx, desired, log)
+	if err != nil {
+		return api.Result{}, errors.Errorf("Unable to check %s: %v", f.Spec.Path, err)
+	}
+	if drift.InSync() {
+		log.Debugf("%s is in sync\n", f.Spec.Path)
+		return api.Result{}, nil
+	}
+	for _, c := range drift.Changes {
+		if c.Diff != "" {
+			log.Infof("%s: %s:\n%s", f.GetName(), c.Message, c.Diff)
+		}
+	}
+	backups, err := f.enforce(ctx, desired, log)
+	// Keep the backups for the report even if enforcing failed part way
+	if err != nil {
+		return api.Result{Backups: backups}, err
+	}
+	return api.Result{
+		Changed: true, Changes: drift.Changes, Backups: backups,
+	}, nil
+}
+
+// Enforce the spec w/ the desired content, returning the backups made of
+// content replaced or removed
+func (f *File) enforce(
+	ctx context.Context, desired []byte, log *logrus.Logger,
+) ([]api.Backup, error) {
 	// Key off ensure setting
-	fs := &f.Spec
+	fs, err := resolvedSpec(&f.Spec, log)
+	if err != nil {
+		return nil, err
+	}
 	switch fs.Ensure {
 	case "absent":
 		log.Debugf("Ensuring %s is absent.\n", fs.Path)
@@ -341,46 +510,363 @@ func (f *File) Apply(ctx context.Context, log *logrus.Logger) error {
 		log.Debugf("Ensuring directory\n")
 		// Ensure dir exists. If fails, throw
 		if err := ensureDirPresent(fs, log); err != nil {
-			return err
+			return nil, err
 		}
 		// Ensure ownership is correct. If fails, throw
 		if err := ensureOwners(fs, log); err != nil {
-			return err
+			return nil, err
 		}
 		// Ensure permissions are correct. If fails, throw
 		if err := ensureMode(fs, log); err != nil {
-			return err
+			return nil, err
 		}
 	case "present":
 		log.Debugf("Ensuring present\n")
-		// Ensure file exists. If fails, throw
-		if err := ensureFilePresent(fs, log); err != nil {
-			return err
+		// Ensure content is correct before touching ownership or permissions,
+		// so any backup keeps the ones the old content had. Writing content
+		// creates the file w/ its final mode and owner, so a file w/ content
+		// is never seen empty. Otherwise just ensure it exists. If fails, throw
+		var backups []api.Backup
+		if hasContent(fs) {
+			var err error
+			if backups, err = ensureFileContent(fs, desired, log); err != nil {
+				return backups, err
+			}
+		} else if err := ensureFilePresent(fs, log); err != nil {
+			return nil, err
 		}
 		// Ensure ownership is correct. If fails, throw
 		if err := ensureOwners(fs, log); err != nil {
-			return err
+			return backups, err
 		}
 		// Ensure permissions are correct. If fails, throw
 		if err := ensureMode(fs, log); err != nil {
-			return err
-		}
-		// Ensure content is correct. If fails, throw
-		if err := ensureFileContent(fs, log); err != nil {
-			return err
+			return backups, err
 		}
+		return backups, nil
 	case "link":
 		log.Debugf("Ensuring link\n")
 		// Ensure link is correct/exists. If fails, throw
 		if err := ensureLink(fs, log); err != nil {
-			return nil
+			return nil, err
 		}
 		// Ensure ownership is correct. If fails, throw
 		if err := ensureOwners(fs, log); err != nil {
-			return err
+			return nil, err
 		}
 	default:
-		return errors.Errorf("Cannot ensure %s", fs.Ensure)
+		return nil, errors.Errorf("Cannot ensure %s", fs.Ensure)
 	}
-	return nil
+	return nil, nil
+}
+
+// Content larger than this, current and expected combined, isn't diffed
+const maxDiffSize = 1 << 20
+
+// Content is treated as binary if it has a NUL byte or isn't valid UTF-8
+func isBinary(data []byte) bool {
+	return bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(data)
+}
+
+// Split content into lines for diffing. Empty content has no lines, and a
+// last line w/o a newline is marked as such.
+func diffLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	last := len(lines) - 1
+	if lines[last] == "" {
+		return lines[:last]
+	}
+	lines[last] += "\n\\ No newline at end of file\n"
+	return lines
+}
+
+// Build a unified diff between the current and expected content of a file
+func contentDiff(fp string, current []byte, expected []byte) (string, error) {
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        diffLines(current),
+		B:        diffLines(expected),
+		FromFile: fp,
+		ToFile:   fp + " (desired)",
+		Context:  3,
+	})
+	if err != nil {
+		return "", errors.Errorf("Unable to diff content of %s: %v", fp, err)
+	}
+	return diff, nil
+}
+
+// Report the mode change needed, if any. exists is false when the file will be
+// created first, in which case the mode will always be set.
+func driftMode(
+	ctx context.Context, f *api.FileSpec, exists bool, log *logrus.Logger,
+) ([]api.Change, error) {
+	setMode, err := desiredMode(f)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return []api.Change{{
+			Attribute: "mode",
+			Message:   fmt.Sprintf("mode will be set to %v", setMode),
+		}}, nil
+	}
+	fm, err := getFileMode(f.Path, log)
+	if err != nil {
+		return nil, errors.Errorf(
+			"Unable to check initial mode for %s: %v", f.Path, err,
+		)
+	}
+	if setMode.Perm() == fm.Perm() {
+		return nil, nil
+	}
+	return []api.Change{{
+		Attribute: "mode",
+		Message:   fmt.Sprintf("mode is %v, expected %v", fm, setMode),
+	}}, nil
+}
+
+// Report the ownership change needed, if any
+func driftOwners(
+	ctx context.Context, f *api.FileSpec, exists bool, log *logrus.Logger,
+) ([]api.Change, error) {
+	expectedUid, expectedGid, err := lookupUidGid(&f.UserID, log)
+	if err != nil {
+		return nil, errors.Errorf("Unable to look up uid/gid: %v", err)
+	}
+	if !exists {
+		return []api.Change{{
+			Attribute: "ownership",
+			Message: fmt.Sprintf(
+				"uid will be set to %d and gid to %d", expectedUid, expectedGid,
+			),
+		}}, nil
+	}
+	uid, gid, err := getFileOwnership(f.Path)
+	if err != nil {
+		return nil, errors.Errorf(
+			"Unable to get initial ownership of %s: %v", f.Path, err,
+		)
+	}
+	if expectedUid == uid && expectedGid == gid {
+		return nil, nil
+	}
+	return []api.Change{{
+		Attribute: "ownership",
+		Message: fmt.Sprintf(
+			"uid is %d, expected %d and gid is %d, expected %d", uid,
+			expectedUid, gid, expectedGid,
+		),
+	}}, nil
+}
+
+// Report the content change needed, if any, w/ a diff against the current
+// content
+func driftContent(
+	fs *api.FileSpec, expectedContent []byte, exists bool,
+) ([]api.Change, error) {
+	var actualContent []byte
+	var err error
+	if exists {
+		actualContent, err = ioutil.ReadFile(fs.Path)
+		if err != nil {
+			return nil, errors.Errorf("Unable to open file %s: %v", fs.Path, err)
+		}
+	}
+	if bytes.Compare(actualContent, expectedContent) == 0 {
+		return nil, nil
+	}
+	change := api.Change{
+		Attribute: "content",
+		Message:   "content differs from expected",
+	}
+	size := len(actualContent) + len(expectedContent)
+	switch {
+	case fs.NoDiff:
+		change.Message += ", diff suppressed"
+	case isBinary(actualContent) || isBinary(expectedContent):
+		change.Message += ", binary content not diffed"
+	case size > maxDiffSize:
+		change.Message += fmt.Sprintf(
+			", %d bytes is too large to diff", size,
+		)
+	default:
+		change.Diff, err = contentDiff(fs.Path, actualContent, expectedContent)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return []api.Change{change}, nil
+}
+
+// True if the spec sets the content of the file, not just that it exists
+func hasContent(fs *api.FileSpec) bool {
+	return fs.Content.Type != ""
+}
+
+// Most links followed resolving a path, as in the kernel
+const maxLinks = 40
+
+// Resolve fp to the file it refers to, following symlinks. A link whose
+// target doesn't exist yet resolves to the missing target. Backups of a file
+// reached through a link are kept under the path it resolves to.
+func ResolveLink(fp string) (string, error) {
+	resolved := fp
+	for i := 0; i < maxLinks; i++ {
+		fi, err := os.Lstat(resolved)
+		if os.IsNotExist(err) {
+			return resolved, nil
+		}
+		if err != nil {
+			return "", errors.Errorf("Unable to lstat %s: %v", resolved, err)
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			return resolved, nil
+		}
+		target, err := os.Readlink(resolved)
+		if err != nil {
+			return "", errors.Errorf("Unable to read link %s: %v", resolved, err)
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(resolved), target)
+		}
+		resolved = target
+	}
+	return "", errors.Errorf("Unable to resolve %s, too many links", fp)
+}
+
+// Get the spec w/ its path resolved to the file it refers to. Files and
+// directories reached through a symlink, like /etc/resolv.conf on many hosts,
+// are managed through it: the content, mode and owner checked and set are the
+// target's, and the link is left alone. Absent and link paths are the link
+// itself.
+func resolvedSpec(fs *api.FileSpec, log *logrus.Logger) (*api.FileSpec, error) {
+	if fs.Ensure != "present" && fs.Ensure != "directory" {
+		return fs, nil
+	}
+	resolved, err := ResolveLink(fs.Path)
+	if err != nil {
+		return nil, err
+	}
+	if resolved == fs.Path {
+		return fs, nil
+	}
+	log.Debugf("%s is a link to %s, managing the target\n", fs.Path, resolved)
+	rs := *fs
+	rs.Path = resolved
+	return &rs, nil
+}
+
+// Resolve the content the spec wants the file to have, or nil if it doesn't
+// set any
+func (f *File) desiredContent(
+	ctx context.Context, log *logrus.Logger,
+) ([]byte, error) {
+	fs := &f.Spec
+	if fs.Ensure != "present" || !hasContent(fs) {
+		return nil, nil
+	}
+	log.Debugf("Loading expected content of %s\n", fs.Path)
+	content, err := getContent(ctx, f, &fs.Content, log)
+	if err != nil {
+		return nil, errors.Errorf(
+			"Unable to load expected file content for %s: %v", fs.Path, err,
+		)
+	}
+	return content, nil
+}
+
+// Compare the file on disk against the spec, without touching the system
+func (f *File) Check(ctx context.Context, log *logrus.Logger) (
+	api.Drift, error,
+) {
+	desired, err := f.desiredContent(ctx, log)
+	if err != nil {
+		return api.Drift{}, err
+	}
+	return f.check(ctx, desired, log)
+}
+
+// Check against already resolved desired content
+func (f *File) check(
+	ctx context.Context, desired []byte, log *logrus.Logger,
+) (api.Drift, error) {
+	fs, err := resolvedSpec(&f.Spec, log)
+	if err != nil {
+		return api.Drift{}, err
+	}
+	var changes []api.Change
+
+	// Lstat to see if anything exists at the path
+	fi, err := os.Lstat(fs.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return api.Drift{}, errors.Errorf("Unable to lstat %s: %v", fs.Path, err)
+	}
+	exists := err == nil
+
+	var checks []func(
+		context.Context, *api.FileSpec, bool, *logrus.Logger,
+	) ([]api.Change, error)
+	switch fs.Ensure {
+	case "absent":
+		if exists {
+			changes = append(changes, api.Change{
+				Attribute: "existence", Message: fs.Path + " exists, expected absent",
+			})
+		}
+		return api.Drift{Changes: changes}, nil
+	case "directory":
+		if !exists {
+			changes = append(changes, api.Change{
+				Attribute: "existence", Message: "directory " + fs.Path + " is missing",
+			})
+		}
+		checks = append(checks, driftOwners, driftMode)
+	case "present":
+		if !exists {
+			changes = append(changes, api.Change{
+				Attribute: "existence", Message: "file " + fs.Path + " is missing",
+			})
+		}
+		checks = append(checks, driftOwners, driftMode)
+		if hasContent(fs) {
+			checks = append(checks, func(
+				context.Context, *api.FileSpec, bool, *logrus.Logger,
+			) ([]api.Change, error) {
+				return driftContent(fs, desired, exists)
+			})
+		}
+	case "link":
+		target := ""
+		if exists && fi.Mode()&os.ModeSymlink != 0 {
+			if target, err = os.Readlink(fs.Path); err != nil {
+				return api.Drift{}, errors.Errorf(
+					"Unable to read link %s: %v", fs.Path, err,
+				)
+			}
+		}
+		if target != fs.Target {
+			changes = append(changes, api.Change{
+				Attribute: "link",
+				Message: fmt.Sprintf(
+					"link points at %q, expected %q", target, fs.Target,
+				),
+			})
+			exists = false
+		}
+		checks = append(checks, driftOwners)
+	default:
+		return api.Drift{}, errors.Errorf("Cannot ensure %s", fs.Ensure)
+	}
+
+	for _, check := range checks {
+		c, err := check(ctx, fs, exists, log)
+		if err != nil {
+			return api.Drift{}, err
+		}
+		changes = append(changes, c...)
+	}
+	return api.Drift{Changes: changes}, nil
 }
@@ -0,0 +1,158 @@
+package resources
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"example.com/jcfg/pkg/api"
+	"example.com/jcfg/pkg/bucket"
+	"github.com/sirupsen/logrus"
+)
+
+func testLogger() *logrus.Logger {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	return log
+}
+
+// A present file at fp w/ content, owned by the user running the test
+func newContentFile(fp string, content string) *File {
+	f := &File{Api: "v1", Kind: "File"}
+	f.Metadata.Name = fp
+	f.Spec = api.FileSpec{
+		Ensure: "present",
+		Path:   fp,
+		Mode:   "0644",
+		UserID: api.UserIdentifierSpec{
+			Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid()),
+		},
+		Content: api.ContentSpec{Type: "string", String: content},
+	}
+	f.Init()
+	return f
+}
+
+func TestFileWritesThroughSymlink(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string // Relative to the test dir
+		old    string // Content of the target, none if empty
+	}{
+		{name: "relative link", target: "real", old: "old\n"},
+		{name: "chained links", target: "chain", old: "old\n"},
+		{name: "dangling link", target: "missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			real := filepath.Join(dir, "real")
+			if tt.old != "" {
+				if err := ioutil.WriteFile(real, []byte(tt.old), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.Symlink("real", filepath.Join(dir, "chain")); err != nil {
+				t.Fatal(err)
+			}
+			link := filepath.Join(dir, "link")
+			if err := os.Symlink(tt.target, link); err != nil {
+				t.Fatal(err)
+			}
+			f := newContentFile(link, "new\n")
+			if _, err := f.Apply(context.Background(), testLogger()); err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+
+			if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+				t.Errorf("link replaced, mode %v (%v)", fi.Mode(), err)
+			}
+			target := filepath.Join(dir, tt.target)
+			if tt.target == "chain" {
+				target = real
+			}
+			data, err := ioutil.ReadFile(target)
+			if err != nil || string(data) != "new\n" {
+				t.Errorf("target %s holds %q (%v)", target, data, err)
+			}
+			if fi, err := os.Stat(target); err != nil || fi.Mode().Perm() != 0644 {
+				t.Errorf("target mode %v, expected 0644 (%v)", fi.Mode(), err)
+			}
+
+			// Check looks at the same file the apply wrote
+			drift, err := f.Check(context.Background(), testLogger())
+			if err != nil {
+				t.Fatalf("Check: %v", err)
+			}
+			if !drift.InSync() {
+				t.Errorf("drift after apply: %+v", drift.Changes)
+			}
+		})
+	}
+}
+
+func TestFileBacksUpSymlinkTarget(t *testing.T) {
+	BackupBucket = bucket.New(t.TempDir())
+	defer func() { BackupBucket = nil }()
+	dir := t.TempDir()
+	real := filepath.Join(dir, "real")
+	if err := ioutil.WriteFile(real, []byte("old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink("real", link); err != nil {
+		t.Fatal(err)
+	}
+	result, err := newContentFile(link, "new\n").Apply(
+		context.Background(), testLogger(),
+	)
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if len(result.Backups) != 1 || result.Backups[0].Path != real {
+		t.Fatalf("backups %+v, expected one of %s", result.Backups, real)
+	}
+	entry, err := BackupBucket.Find(real, result.Backups[0].Sum)
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if data, err := BackupBucket.Content(entry); err != nil || string(data) != "old\n" {
+		t.Errorf("backup holds %q (%v)", data, err)
+	}
+}
+
+func TestFileContentNeedsRegularFile(t *testing.T) {
+	dir := t.TempDir()
+	fp := filepath.Join(dir, "sub")
+	if err := os.Mkdir(fp, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newContentFile(fp, "new\n").Apply(
+		context.Background(), testLogger(),
+	); err == nil {
+		t.Errorf("Apply replaced a directory w/ content")
+	}
+	if fi, err := os.Lstat(fp); err != nil || !fi.IsDir() {
+		t.Errorf("directory replaced, mode %v (%v)", fi.Mode(), err)
+	}
+}
+
+func TestFileLinkLoop(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	if err := os.Symlink(b, a); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(a, b); err != nil {
+		t.Fatal(err)
+	}
+	f := newContentFile(a, "new\n")
+	if _, err := f.Apply(context.Background(), testLogger()); err == nil {
+		t.Errorf("Apply wrote through a link loop")
+	}
+	if fi, err := os.Lstat(a); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("link in loop replaced, mode %v (%v)", fi.Mode(), err)
+	}
+}
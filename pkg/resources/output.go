@@ -0,0 +1,189 @@
+package resources
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"example.com/jcfg/pkg/api"
+	"github.com/pkg/errors"
+)
+
+// Check the expectations on a stream are usable
+func validateExpectation(stream string, oe *api.OutputExpectation) error {
+	if oe.Regex != "" {
+		if _, err := regexp.Compile(oe.Regex); err != nil {
+			return errors.Errorf(
+				"unable to compile %s regex %s: %v", stream, oe.Regex, err,
+			)
+		}
+	}
+	for _, je := range oe.JSON {
+		if je.Path == "" {
+			return errors.Errorf("%s json expectation missing a path", stream)
+		}
+	}
+	return nil
+}
+
+// Match output against expectations, returning an error describing the first
+// that doesn't match
+func matchOutput(output string, oe *api.OutputExpectation) error {
+	if oe.Exact != nil {
+		trimmed := strings.TrimRight(output, "\n")
+		if trimmed != strings.TrimRight(*oe.Exact, "\n") {
+			return errors.Errorf("%q != expected %q", trimmed, *oe.Exact)
+		}
+	}
+	if oe.Contains != "" && !strings.Contains(output, oe.Contains) {
+		return errors.Errorf("Doesn't contain %q", oe.Contains)
+	}
+	if oe.Regex != "" {
+		re, err := regexp.Compile(oe.Regex)
+		if err != nil {
+			return errors.Errorf("Unable to compile regex %s: %v", oe.Regex, err)
+		}
+		if !re.MatchString(output) {
+			return errors.Errorf("Doesn't match regex %s", oe.Regex)
+		}
+	}
+	if len(oe.JSON) == 0 {
+		return nil
+	}
+	var doc interface{}
+	if err := json.Unmarshal([]byte(output), &doc); err != nil {
+		return errors.Errorf("Unable to parse as json: %v", err)
+	}
+	for _, je := range oe.JSON {
+		value, err := jsonPath(doc, je.Path)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(value, je.Value) {
+			return errors.Errorf(
+				"Value %v at %s != expected %v", value, je.Path, je.Value,
+			)
+		}
+	}
+	return nil
+}
+
+// Match captured output against expectations. Expectations can't be checked
+// against output that was dropped.
+func matchCaptured(c *outputCapture, oe *api.OutputExpectation) error {
+	set := oe.Exact != nil || oe.Contains != "" || oe.Regex != "" ||
+		len(oe.JSON) != 0
+	if !set {
+		return nil
+	}
+	if c.Truncated() {
+		return errors.Errorf("Unable to match, output over the limit was dropped")
+	}
+	return matchOutput(c.Kept(), oe)
+}
+
+// Look up the value at a dot separated path in a decoded json document.
+// Segments index objects by key and arrays by position.
+func jsonPath(doc interface{}, path string) (interface{}, error) {
+	value := doc
+	for _, segment := range strings.Split(strings.TrimPrefix(path, "."), ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			next, ok := v[segment]
+			if !ok {
+				return nil, errors.Errorf("No key %s at json path %s", segment, path)
+			}
+			value = next
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil, errors.Errorf(
+					"No index %s at json path %s", segment, path,
+				)
+			}
+			value = v[index]
+		default:
+			return nil, errors.Errorf(
+				"Unable to index %s at json path %s, not an object or array",
+				segment, path,
+			)
+		}
+	}
+	return value, nil
+}
+
+// Check a named output of an exec is usable
+func validateExecOutput(o *api.ExecOutput) error {
+	if o.Name == "" {
+		return errors.Errorf("output missing a name")
+	}
+	switch o.Stream {
+	case "", "stdout", "stderr":
+	default:
+		return errors.Errorf(
+			"output %s has unknown stream %s, expected stdout or stderr",
+			o.Name, o.Stream,
+		)
+	}
+	if o.Regex != "" && o.JSON != "" {
+		return errors.Errorf("output %s sets both regex and json", o.Name)
+	}
+	if o.Regex != "" {
+		re, err := regexp.Compile(o.Regex)
+		if err != nil {
+			return errors.Errorf(
+				"unable to compile output %s regex %s: %v", o.Name, o.Regex, err,
+			)
+		}
+		if o.Group < 0 || o.Group > re.NumSubexp() {
+			return errors.Errorf(
+				"output %s regex %s has no group %d", o.Name, o.Regex, o.Group,
+			)
+		}
+	}
+	return nil
+}
+
+// Extract a named output from the output of a command. JSON values that
+// aren't strings are published as json.
+func extractOutput(o *api.ExecOutput, stdout string, stderr string) (
+	string, error,
+) {
+	output := stdout
+	if o.Stream == "stderr" {
+		output = stderr
+	}
+	switch {
+	case o.Regex != "":
+		re, err := regexp.Compile(o.Regex)
+		if err != nil {
+			return "", errors.Errorf("Unable to compile regex %s: %v", o.Regex, err)
+		}
+		match := re.FindStringSubmatch(output)
+		if match == nil {
+			return "", errors.Errorf("No match for regex %s", o.Regex)
+		}
+		return match[o.Group], nil
+	case o.JSON != "":
+		var doc interface{}
+		if err := json.Unmarshal([]byte(output), &doc); err != nil {
+			return "", errors.Errorf("Unable to parse as json: %v", err)
+		}
+		value, err := jsonPath(doc, o.JSON)
+		if err != nil {
+			return "", err
+		}
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return "", errors.Errorf("Unable to marshal value at %s: %v", o.JSON, err)
+		}
+		return string(data), nil
+	default:
+		return strings.TrimSpace(output), nil
+	}
+}
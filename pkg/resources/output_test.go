@@ -0,0 +1,143 @@
+package resources
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"example.com/jcfg/pkg/api"
+)
+
+func TestJSONPath(t *testing.T) {
+	doc := decode(t, `{
+		"status": {"units": [{"name": "sshd", "pid": 12}], "ok": true},
+		"count": 3,
+		"ratio": 0.5
+	}`)
+	tests := []struct {
+		path string
+		want interface{}
+		err  string
+	}{
+		{path: "status.units.0.name", want: "sshd"},
+		{path: ".status.units.0.name", want: "sshd"},
+		{path: "status.units.0.pid", want: float64(12)},
+		{path: "status.ok", want: true},
+		{path: "ratio", want: 0.5},
+		{path: "status.units.1", err: "No index 1"},
+		{path: "status.units.-1", err: "No index -1"},
+		{path: "status.units.name", err: "No index name"},
+		{path: "status.missing", err: "No key missing"},
+		{path: "count.value", err: "not an object or array"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := jsonPath(doc, tt.path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error %q, got %v, %v", tt.err, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("jsonPath: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, expected %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		expect string // OutputExpectation as it'd appear in a catalog
+		err    string
+	}{
+		{
+			name: "exact ignores trailing newlines", output: "enabled\n",
+			expect: `{"Exact": "enabled"}`,
+		},
+		{
+			name: "exact mismatch", output: "disabled\n",
+			expect: `{"Exact": "enabled"}`, err: `"disabled" != expected "enabled"`,
+		},
+		{
+			name: "empty exact is checked", output: "x",
+			expect: `{"Exact": ""}`, err: "!= expected",
+		},
+		{
+			name: "contains", output: "Active: active (running)",
+			expect: `{"Contains": "running"}`,
+		},
+		{
+			name: "regex", output: "version 1.2.3\n",
+			expect: `{"Regex": "^version [0-9.]+$"}`, err: "Doesn't match",
+		},
+		{
+			name: "regex multiline", output: "version 1.2.3\n",
+			expect: `{"Regex": "(?m)^version [0-9.]+$"}`,
+		},
+		{
+			name: "json int matches float", output: `{"count": 3.0}`,
+			expect: `{"JSON": [{"Path": "count", "Value": 3}]}`,
+		},
+		{
+			name: "json number isn't a string", output: `{"count": 3}`,
+			expect: `{"JSON": [{"Path": "count", "Value": "3"}]}`,
+			err:    "Value 3 at count != expected 3",
+		},
+		{
+			name: "json nested", output: `{"units": [{"name": "sshd", "on": true}]}`,
+			expect: `{"JSON": [
+				{"Path": "units.0.name", "Value": "sshd"},
+				{"Path": "units.0.on", "Value": true}
+			]}`,
+		},
+		{
+			name: "json object value", output: `{"a": {"b": [1, "x"]}}`,
+			expect: `{"JSON": [{"Path": "a", "Value": {"b": [1, "x"]}}]}`,
+		},
+		{
+			name: "json missing key", output: `{"a": 1}`,
+			expect: `{"JSON": [{"Path": "b", "Value": 1}]}`, err: "No key b",
+		},
+		{
+			name: "not json", output: "plain text",
+			expect: `{"JSON": [{"Path": "a", "Value": 1}]}`,
+			err:    "Unable to parse as json",
+		},
+		{
+			name: "all must match", output: `{"state": "up"}`,
+			expect: `{"Contains": "up", "JSON": [{"Path": "state", "Value": "down"}]}`,
+			err:    "Value up at state != expected down",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var oe api.OutputExpectation
+			if err := json.Unmarshal([]byte(tt.expect), &oe); err != nil {
+				t.Fatalf("bad expectation: %v", err)
+			}
+			err := matchOutput(tt.output, &oe)
+			if tt.err == "" && err != nil {
+				t.Errorf("expected a match, got %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func decode(t *testing.T, data string) interface{} {
+	t.Helper()
+	var doc interface{}
+	if err := json.Unmarshal([]byte(data), &doc); err != nil {
+		t.Fatalf("bad json: %v", err)
+	}
+	return doc
+}
@@ -0,0 +1,241 @@
+package resources
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"example.com/jcfg/pkg/api"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Version of the plugin protocol sent w/ every request
+const PluginProtocolVersion = 1
+
+// Prefix of plugin executables. A plugin for kind Foo is named
+// jcfg-resource-foo.
+const PluginPrefix = "jcfg-resource-"
+
+// Directories searched for plugin executables. If empty, PATH is searched.
+var PluginPath []string
+
+// Request written to the plugin on stdin
+type PluginRequest struct {
+	Protocol int             `json:"protocol"`
+	Action   string          `json:"action"` // validate, check, apply or refresh
+	Resource json.RawMessage `json:"resource"`
+}
+
+// Response read from the plugin on stdout
+type PluginResponse struct {
+	State   string       `json:"state"` // completed, failed or skipped
+	Changed bool         `json:"changed"`
+	Changes []api.Change `json:"changes"`
+	Error   string       `json:"error"`
+	Stdout  string       `json:"stdout"`
+	Stderr  string       `json:"-"` // Captured from the plugin's stderr
+}
+
+// Resource whose check and apply are delegated to an external executable
+type Plugin struct {
+	Api        string
+	Kind       string
+	Metadata   api.MetadataDef
+	Spec       json.RawMessage
+	executable string
+	raw        json.RawMessage // Catalog entry, passed to the plugin as is
+}
+
+// Keep the raw catalog entry around while unmarshalling
+func (p *Plugin) UnmarshalJSON(data []byte) error {
+	type plain Plugin
+	if err := json.Unmarshal(data, (*plain)(p)); err != nil {
+		return err
+	}
+	p.raw = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+func (p *Plugin) GetApi() string {
+	return p.Api
+}
+func (p *Plugin) GetKind() string {
+	return p.Kind
+}
+func (p *Plugin) GetName() string {
+	return strings.Title(p.Kind) + "::" + p.Metadata.Name
+}
+func (p *Plugin) GetMetadata() api.MetadataDef {
+	return p.Metadata
+}
+func (p *Plugin) Fail(log *logrus.Logger, err error) error {
+	if tErr := p.Transition(api.PhaseFailed, err.Error()); tErr != nil {
+		return errors.Errorf("%v: %v", err, tErr)
+	}
+	return err
+}
+func (p *Plugin) Done() error {
+	return p.Transition(api.PhaseCompleted, "")
+}
+func (p *Plugin) Transition(to api.Phase, reason string) error {
+	return p.Metadata.State.Transition(to, reason)
+}
+
+func (p *Plugin) Init() {
+	p.Metadata.State = api.StateDef{Phase: api.PhasePending}
+}
+
+// Search the plugin path for the executable implementing kind
+func FindPlugin(kind string) (string, error) {
+	name := PluginPrefix + strings.ToLower(kind)
+	if len(PluginPath) == 0 {
+		fp, err := exec.LookPath(name)
+		if err != nil {
+			return "", errors.Errorf("Unable to find plugin %s in PATH: %v", name, err)
+		}
+		return fp, nil
+	}
+	for _, dir := range PluginPath {
+		fp := filepath.Join(dir, name)
+		fi, err := os.Stat(fp)
+		if err == nil && fi.Mode().IsRegular() && fi.Mode().Perm()&0111 != 0 {
+			return fp, nil
+		}
+	}
+	return "", errors.Errorf(
+		"Unable to find plugin %s in %s", name,
+		strings.Join(PluginPath, string(filepath.ListSeparator)),
+	)
+}
+
+// Build a resource kind backed by the plugin for kind, if there is one.
+// Validation is delegated to the plugin too.
+func PluginKind(apiVersion string, kind string) (*Kind, error) {
+	fp, err := FindPlugin(kind)
+	if err != nil {
+		return nil, err
+	}
+	return &Kind{
+		Api:  apiVersion,
+		Kind: kind,
+		New:  func() api.Resource { return &Plugin{executable: fp} },
+		Validate: func(r api.Resource) error {
+			p, ok := r.(*Plugin)
+			if !ok {
+				return errors.Errorf("Resource %s is not a Plugin", r.GetName())
+			}
+			resp, err := p.call(context.Background(), "validate", nil)
+			if err != nil {
+				return err
+			}
+			if resp.State == "failed" {
+				return errors.Errorf("%s: %s", p.GetName(), resp.Error)
+			}
+			return nil
+		},
+	}, nil
+}
+
+// Run the plugin for a single action, returning its parsed response. Errors
+// are only returned if the plugin couldn't be run or spoke the protocol
+// wrong; a resource failure is reported in the response state.
+func (p *Plugin) call(
+	ctx context.Context, action string, log *logrus.Logger,
+) (PluginResponse, error) {
+	var resp PluginResponse
+	req, err := json.Marshal(PluginRequest{
+		Protocol: PluginProtocolVersion,
+		Action:   action,
+		Resource: p.raw,
+	})
+	if err != nil {
+		return resp, errors.Errorf("Unable to marshal plugin request: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.executable)
+	cmd.Stdin = bytes.NewReader(req)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+	if log != nil && stderr.Len() != 0 {
+		log.Infof("%s Plugin stderr:\n%s\n", p.GetName(), stderr.String())
+	}
+
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		if runErr != nil {
+			return resp, errors.Errorf(
+				"Plugin %s failed: %v: %s", p.executable, runErr, stderr.String(),
+			)
+		}
+		return resp, errors.Errorf(
+			"Unable to parse response from plugin %s: %v", p.executable, err,
+		)
+	}
+	resp.Stderr = stderr.String()
+	switch resp.State {
+	case "completed", "failed", "skipped":
+	default:
+		return resp, errors.Errorf(
+			"Plugin %s returned unknown state %q", p.executable, resp.State,
+		)
+	}
+	return resp, nil
+}
+
+// Ask the plugin what would change
+func (p *Plugin) Check(ctx context.Context, log *logrus.Logger) (
+	api.Drift, error,
+) {
+	resp, err := p.call(ctx, "check", log)
+	if err != nil {
+		return api.Drift{}, err
+	}
+	if resp.State == "failed" {
+		return api.Drift{}, errors.Errorf("Plugin check failed: %s", resp.Error)
+	}
+	return api.Drift{Changes: resp.Changes}, nil
+}
+
+// Have the plugin enforce the resource
+func (p *Plugin) Apply(ctx context.Context, log *logrus.Logger) (
+	api.Result, error,
+) {
+	return p.act(ctx, "apply", log)
+}
+
+// Have the plugin refresh the resource after a resource notifying it changed
+func (p *Plugin) Refresh(ctx context.Context, log *logrus.Logger) (
+	api.Result, error,
+) {
+	return p.act(ctx, "refresh", log)
+}
+
+// Run an action that changes the system, mapping the plugin's response state
+// back onto ours
+func (p *Plugin) act(
+	ctx context.Context, action string, log *logrus.Logger,
+) (api.Result, error) {
+	resp, err := p.call(ctx, action, log)
+	if err != nil {
+		return api.Result{}, err
+	}
+	result := api.Result{
+		Changed: resp.Changed,
+		Changes: resp.Changes,
+		Stdout:  resp.Stdout,
+		Stderr:  resp.Stderr,
+	}
+	switch resp.State {
+	case "failed":
+		return result, errors.Errorf("Plugin %s failed: %s", action, resp.Error)
+	case "skipped":
+		return result, &api.SkipError{Reason: resp.Error}
+	}
+	return result, nil
+}
@@ -0,0 +1,71 @@
+package resources
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"example.com/jcfg/pkg/api"
+	"github.com/pkg/errors"
+)
+
+// Definition of a resource kind. New returns an empty resource for a catalog
+// entry to be unmarshalled into, Validate checks the loaded resource before
+// it's added to a catalog. Validate may be nil.
+type Kind struct {
+	Api      string
+	Kind     string
+	New      func() api.Resource
+	Validate func(api.Resource) error
+}
+
+var (
+	registryLock sync.RWMutex
+	registry     = make(map[string]*Kind)
+)
+
+// Kinds are matched case insensitively, as catalogs mix "File" and "file"
+func registryKey(apiVersion string, kind string) string {
+	return strings.ToLower(apiVersion) + "/" + strings.ToLower(kind)
+}
+
+// Register a resource kind so catalogs can use it. Meant to be called from
+// init() of the package defining the kind, so panics if the kind is
+// malformed or already registered.
+func Register(k Kind) {
+	if k.Api == "" || k.Kind == "" || k.New == nil {
+		panic("resources: Register called w/o api, kind or constructor")
+	}
+	key := registryKey(k.Api, k.Kind)
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if _, ok := registry[key]; ok {
+		panic("resources: Register called twice for kind " + key)
+	}
+	registry[key] = &k
+}
+
+// Look up a registered resource kind
+func Lookup(apiVersion string, kind string) (*Kind, error) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	k, ok := registry[registryKey(apiVersion, kind)]
+	if !ok {
+		return nil, errors.Errorf(
+			"Kind %s not registered for api %s", kind, apiVersion,
+		)
+	}
+	return k, nil
+}
+
+// List the registered kinds as api/kind, sorted
+func Kinds() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	keys := make([]string, 0, len(registry))
+	for key := range registry {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,203 @@
+package resources
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+
+	"example.com/jcfg/pkg/api"
+	"example.com/jcfg/pkg/facts"
+	"github.com/pkg/errors"
+)
+
+// Helpers available to templates. Functions taking the value being worked on
+// take it last, so they read naturally in pipelines, e.g.
+// {{ .Vars.name | default "web" | upper }}
+var templateFuncs = template.FuncMap{
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"title":      strings.Title,
+	"trim":       strings.TrimSpace,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":       templateJoin,
+	"indent":     templateIndent,
+	"quote":      func(v interface{}) string { return fmt.Sprintf("%q", fmt.Sprint(v)) },
+	"default":    templateDefault,
+	"required":   templateRequired,
+	"keys":       templateKeys,
+	"toJSON":     templateJSON,
+}
+
+// What a template is rendered w/. Facts are a method so they're only
+// gathered if a template uses them.
+type templateData struct {
+	env      *api.TemplateEnv
+	Vars     map[string]interface{} // Catalog vars
+	Resource templateResource       // The resource being applied
+}
+
+type templateResource struct {
+	Key         string // <Kind>::<Name>
+	Kind        string
+	Name        string
+	Description string
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+// Facts about the node
+func (d *templateData) Facts() (*facts.Facts, error) {
+	return d.env.Facts()
+}
+
+// Check a template content source is usable, parsing inline templates so
+// syntax errors are caught when the catalog loads
+func validateTemplate(name string, ts *api.TemplateSpec) error {
+	if (ts.Inline == "") == (ts.Source == "") {
+		return errors.Errorf("template needs exactly one of inline or source")
+	}
+	if ts.Inline != "" {
+		if _, err := parseTemplate(name, ts.Inline); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parse a template named for the resource it belongs to, so errors in it are
+// reported against the resource
+func parseTemplate(name string, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).
+		Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, errors.Errorf("Unable to parse template: %v", err)
+	}
+	return tmpl, nil
+}
+
+// Render a template for r w/ the template env attached to ctx
+func renderTemplate(
+	ctx context.Context, r api.Resource, ts *api.TemplateSpec,
+) ([]byte, error) {
+	text := ts.Inline
+	if ts.Source != "" {
+		data, err := ioutil.ReadFile(ts.Source)
+		if err != nil {
+			return nil, errors.Errorf(
+				"Unable to read template source %s: %v", ts.Source, err,
+			)
+		}
+		text = string(data)
+	}
+	tmpl, err := parseTemplate(r.GetName(), text)
+	if err != nil {
+		return nil, err
+	}
+	env := api.TemplateEnvFrom(ctx)
+	if env == nil {
+		env = api.NewTemplateEnv(nil, facts.Gather)
+	}
+	meta := r.GetMetadata()
+	data := &templateData{
+		env:  env,
+		Vars: env.Vars,
+		Resource: templateResource{
+			Key:         r.GetName(),
+			Kind:        strings.Title(r.GetKind()),
+			Name:        meta.Name,
+			Description: meta.Description,
+			Labels:      meta.Labels,
+			Annotations: meta.Annotations,
+		},
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return nil, errors.Errorf("Unable to render template: %v", err)
+	}
+	return out.Bytes(), nil
+}
+
+// Join a list of strings, or of any values from catalog vars
+func templateJoin(sep string, list interface{}) (string, error) {
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return "", errors.Errorf("join expects a list, got %T", list)
+	}
+	elems := make([]string, v.Len())
+	for i := range elems {
+		elems[i] = fmt.Sprint(v.Index(i).Interface())
+	}
+	return strings.Join(elems, sep), nil
+}
+
+// Indent every line of s by n spaces
+func templateIndent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+}
+
+// Is v nil, false, zero or empty
+func templateEmpty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Array, reflect.String:
+		return rv.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return rv.IsNil()
+	}
+	return reflect.DeepEqual(v, reflect.Zero(rv.Type()).Interface())
+}
+
+// Use def if v is empty
+func templateDefault(def interface{}, v interface{}) interface{} {
+	if templateEmpty(v) {
+		return def
+	}
+	return v
+}
+
+// Fail rendering w/ msg if v is empty
+func templateRequired(msg string, v interface{}) (interface{}, error) {
+	if templateEmpty(v) {
+		return nil, errors.New(msg)
+	}
+	return v, nil
+}
+
+// Sorted keys of a map, to range over it in a stable order
+func templateKeys(m interface{}) ([]string, error) {
+	v := reflect.ValueOf(m)
+	if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+		return nil, errors.Errorf("keys expects a map w/ string keys, got %T", m)
+	}
+	keys := make([]string, 0, v.Len())
+	for _, k := range v.MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Marshal v as json
+func templateJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", errors.Errorf("Unable to marshal %v as json: %v", v, err)
+	}
+	return string(data), nil
+}